@@ -1,7 +1,16 @@
 package main
 
 import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
 	"math/rand"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -11,49 +20,266 @@ const (
 	PatternAA
 	PatternFF
 	PatternRand
+	PatternCustom
 )
 
+const (
+	CompressionSegment = 4096     // Granularity of random and repeated data when targeting a compression ratio
+	CompressionSample  = 4 * 1024 // KiB of buffer data compressed when measuring the achieved ratio
+	DedupeBlockSize    = 4096     // Granularity at which written blocks are stamped to make them unique
+	DedupePoolBlocks   = 16       // Number of distinct blocks repeated blocks are drawn from
+	StampSize          = 16       // Bytes of seed and counter stamped into each unique block
+)
+
+// DataConfig describes the content a dataReader generates.
+type DataConfig struct {
+	Compression float64 // Target compression ratio, e.g. 2 for 2:1. Values of 1 or less disable mixing.
+	Custom      []byte  // Bytes tiled into the buffer for PatternCustom
+	Dedupe      float64 // Fraction of blocks filled from the shared repeat pool instead of being stamped
+	DedupeSeed  int64   // Seed for the repeat pool. Readers sharing it repeat each other's blocks.
+	Pattern     int
+	Seed        int64 // Seed for random content and the starting position. Zero seeds from the clock.
+	Unique      bool  // Stamp each DedupeBlockSize block read with the reader's seed and a counter
+}
+
 type dataReader struct {
+	counter  uint64
 	data     []byte
+	dedupe   float64
+	pool     []byte
 	position int
+	seed     uint64
+	unique   bool
 }
 
 func NewDataReader(size int, pattern int) *dataReader {
-	//var total int
+	return NewDataReaderFromConfig(size, DataConfig{Pattern: pattern})
+}
+
+func NewDataReaderFromConfig(size int, config DataConfig) *dataReader {
 	// Create 64K minimum size buffer
 	if size < 65536 {
 		size = 65536
 	}
+	custom := config.Pattern == PatternCustom && len(config.Custom) > 0
+	if custom {
+		// Keep the buffer a whole number of patterns, so the tiling survives wrapping around.
+		size = (size + len(config.Custom) - 1) / len(config.Custom) * len(config.Custom)
+	}
 
-	s := rand.NewSource(time.Now().UnixNano())
+	seed := config.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	s := rand.NewSource(seed)
 	r := rand.New(s)
 	data := make([]byte, size)
-	initialPosition := rand.Intn(len(data))
+	initialPosition := r.Intn(len(data))
+	if custom {
+		initialPosition -= initialPosition % len(config.Custom)
+	}
 
-	// TODO: Handle pattern rather than zero
-	switch pattern {
+	fillData(data, r, config)
+
+	dr := &dataReader{
+		data:     data,
+		dedupe:   config.Dedupe,
+		position: initialPosition,
+		seed:     r.Uint64(),
+		unique:   config.Unique || config.Dedupe > 0,
+	}
+	if config.Dedupe > 0 {
+		// The pool is generated like the buffer, so repeated blocks keep the pattern and compressibility.
+		dr.pool = make([]byte, DedupePoolBlocks*DedupeBlockSize)
+		fillData(dr.pool, rand.New(rand.NewSource(config.DedupeSeed)), config)
+	}
+	return dr
+}
+
+// fillData fills data with the configured pattern, mixing in random bytes from r to reach the target compression.
+func fillData(data []byte, r *rand.Rand, config DataConfig) {
+	var fill byte
+
+	switch config.Pattern {
 	case Pattern55:
-		for i := range data {
-			data[i] = 0x55
-		}
+		fill = 0x55
 	case PatternAA:
-		for i := range data {
-			data[i] = 0xAA
-		}
+		fill = 0xAA
 	case PatternFF:
-		for i := range data {
-			data[i] = 0xFF
+		fill = 0xFF
+	}
+
+	switch {
+	case config.Pattern == PatternCustom && len(config.Custom) > 0:
+		for i := 0; i < len(data); i += len(config.Custom) {
+			copy(data[i:], config.Custom)
 		}
-	case PatternZero:
+	case config.Pattern == PatternRand && config.Compression <= 1:
+		r.Read(data)
+	case fill != 0:
 		for i := range data {
-			data[i] = 0x00
+			data[i] = fill
+		}
+	}
+
+	if config.Compression > 1 {
+		// Each segment starts with enough random bytes to make the whole segment
+		// compress at roughly the target ratio, and the remainder keeps the pattern.
+		randomBytes := int(float64(CompressionSegment) / config.Compression)
+		for start := 0; start < len(data); start += CompressionSegment {
+			segment := data[start:]
+			if len(segment) > CompressionSegment {
+				segment = segment[:CompressionSegment]
+			}
+			if randomBytes < len(segment) {
+				segment = segment[:randomBytes]
+			}
+			r.Read(segment)
 		}
+	}
+}
+
+// mix64 scrambles a counter into a well distributed value (the splitmix64 finalizer).
+func mix64(v uint64) uint64 {
+	v ^= v >> 30
+	v *= 0xbf58476d1ce4e5b9
+	v ^= v >> 27
+	v *= 0x94d049bb133111eb
+	v ^= v >> 31
+	return v
+}
+
+// deriveSeed mixes a base seed with the parts identifying a random stream, so every stream
+// gets a distinct seed which is still reproducible from the base seed.
+func deriveSeed(base int64, parts ...int64) int64 {
+	v := mix64(uint64(base))
+	for _, part := range parts {
+		v = mix64(v ^ (uint64(part) + 0x9e3779b97f4a7c15))
+	}
+	return int64(v)
+}
+
+// stamp writes the reader's seed and a block counter at the start of every DedupeBlockSize block in p.
+// Blocks chosen to repeat are instead copied from the repeat pool, so they duplicate earlier blocks from
+// the first write on, and duplicate the blocks of every reader sharing the pool's seed.
+func (r *dataReader) stamp(p []byte) {
+	for start := 0; start+StampSize <= len(p); start += DedupeBlockSize {
+		r.counter++
+		if r.dedupe > 0 {
+			choice := mix64(r.seed ^ r.counter)
+			if float64(choice>>11)/(1<<53) < r.dedupe {
+				block := int(choice%DedupePoolBlocks) * DedupeBlockSize
+				copy(p[start:], r.pool[block:block+DedupeBlockSize])
+				continue
+			}
+		}
+		binary.LittleEndian.PutUint64(p[start:], r.seed)
+		binary.LittleEndian.PutUint64(p[start+8:], r.counter)
+	}
+}
+
+// parseCompression converts a compression ratio in the form 2:1 or 2 into a float.
+func parseCompression(ratio string) (float64, error) {
+	ratio = strings.TrimSuffix(strings.TrimSpace(ratio), ":1")
+	value, err := strconv.ParseFloat(ratio, 64)
+	if err != nil {
+		return 0, fmt.Errorf("compression ratio %s is invalid", ratio)
+	}
+	if value < 1 {
+		return 0, fmt.Errorf("compression ratio %s must be at least 1:1", ratio)
+	}
+	return value, nil
+}
+
+// compressionRatio returns the ratio DEFLATE achieves on up to the first CompressionSample KiB of data.
+func compressionRatio(data []byte) float64 {
+	var compressed bytes.Buffer
+
+	if len(data) > CompressionSample*KiB {
+		data = data[:CompressionSample*KiB]
+	}
+	w, err := flate.NewWriter(&compressed, flate.DefaultCompression)
+	if err != nil {
+		return 0
+	}
+	_, _ = w.Write(data)
+	_ = w.Close()
+	if compressed.Len() == 0 {
+		return 0
+	}
+
+	return float64(len(data)) / float64(compressed.Len())
+}
+
+// parsePattern converts a command line byte pattern into a pattern constant, and the bytes of a custom pattern.
+// Besides the named patterns, a hex string such as deadbeef or 0xdeadbeef, walk1 (walking ones), and walk0
+// (walking zeros) are accepted.
+func parsePattern(name string) (int, []byte, error) {
+	name = strings.ToLower(name)
+	switch name {
+	case "zero", "00":
+		return PatternZero, nil, nil
+	case "random":
+		return PatternRand, nil, nil
+	case "55":
+		return Pattern55, nil, nil
+	case "aa":
+		return PatternAA, nil, nil
+	case "ff":
+		return PatternFF, nil, nil
+	case "walk1":
+		return PatternCustom, []byte{0x01, 0x02, 0x04, 0x08, 0x10, 0x20, 0x40, 0x80}, nil
+	case "walk0":
+		return PatternCustom, []byte{0xFE, 0xFD, 0xFB, 0xF7, 0xEF, 0xDF, 0xBF, 0x7F}, nil
+	}
+
+	if custom, err := hex.DecodeString(strings.TrimPrefix(name, "0x")); err == nil && len(custom) > 0 {
+		return PatternCustom, custom, nil
+	}
+	return 0, nil, fmt.Errorf("byte pattern must be 55, AA, FF, random, zero, walk0, walk1, or a hex string. %s is invalid", name)
+}
+
+// loadPattern reads a pattern file, such as a captured database page, to be tiled into data buffers.
+func loadPattern(patternPath string) ([]byte, error) {
+	custom, err := os.ReadFile(patternPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(custom) == 0 {
+		return nil, fmt.Errorf("pattern file %s is empty", patternPath)
+	}
+	return custom, nil
+}
+
+// patternName returns the display name of a byte pattern constant.
+func patternName(pattern int) string {
+	switch pattern {
+	case PatternZero:
+		return "00"
+	case Pattern55:
+		return "55"
+	case PatternAA:
+		return "AA"
+	case PatternFF:
+		return "FF"
 	case PatternRand:
-		r.Read(data)
+		return "random"
+	case PatternCustom:
+		return "custom"
 	}
+	return "unknown"
+}
 
-	dr := &dataReader{data: data, position: initialPosition}
-	return dr
+// PatternName returns the display name of the configured pattern. Short custom patterns are shown as hex,
+// and long ones by their length and checksum. Names never contain spaces.
+func (c DataConfig) PatternName() string {
+	if c.Pattern != PatternCustom {
+		return patternName(c.Pattern)
+	}
+	if len(c.Custom) <= 16 {
+		return strings.ToUpper(hex.EncodeToString(c.Custom))
+	}
+	return fmt.Sprintf("custom:%d:%08x", len(c.Custom), crc32.ChecksumIEEE(c.Custom))
 }
 
 func (r *dataReader) Read(p []byte) (int, error) {
@@ -69,5 +295,8 @@ func (r *dataReader) Read(p []byte) (int, error) {
 			r.position = 0
 		}
 	}
+	if r.unique {
+		r.stamp(p)
+	}
 	return total, nil
 }
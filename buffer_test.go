@@ -1,7 +1,9 @@
 package main
 
 import (
+	"bytes"
 	"io"
+	"math"
 	"math/rand"
 	"testing"
 	"time"
@@ -108,6 +110,67 @@ func TestDataReaderValues(t *testing.T) {
 	}
 }
 
+func TestDataReaderCustom(t *testing.T) {
+	_, walk1, err := parsePattern("walk1")
+	if err != nil {
+		t.Fatalf("Unable to parse walk1. %s\n", err)
+	}
+	// A 3 byte pattern does not divide the buffer size evenly, so reading past the wrap checks the tiling.
+	for _, custom := range [][]byte{{0xDE, 0xAD, 0xBE, 0xEF}, {0x01, 0x02, 0x03}, walk1} {
+		data := make([]byte, 256*1024)
+		dataReader := NewDataReaderFromConfig(65536, DataConfig{Custom: custom, Pattern: PatternCustom})
+		if copied, _ := dataReader.Read(data); copied < len(data) {
+			t.Errorf("Copy from pattern buffer failed. Coped %d bytes.\n", copied)
+		}
+		for i := range data {
+			if data[i] != custom[i%len(custom)] {
+				t.Errorf("Pattern %X expected %X at %d, read %X.\n", custom, custom[i%len(custom)], i, data[i])
+				break
+			}
+		}
+	}
+}
+
+func TestParsePattern(t *testing.T) {
+	if pattern, custom, err := parsePattern("0xDEADbeef"); err != nil || pattern != PatternCustom || len(custom) != 4 || custom[0] != 0xDE {
+		t.Errorf("Failed to parse hex pattern. %d, %X, %v\n", pattern, custom, err)
+	}
+	if pattern, _, err := parsePattern("AA"); err != nil || pattern != PatternAA {
+		t.Errorf("Failed to parse AA pattern. %d, %v\n", pattern, err)
+	}
+	if _, _, err := parsePattern("abc"); err == nil {
+		t.Error("Accepted an odd length hex pattern.")
+	}
+	if _, _, err := parsePattern("bogus"); err == nil {
+		t.Error("Accepted an invalid pattern name.")
+	}
+}
+
+func TestDataReaderSeed(t *testing.T) {
+	config := DataConfig{Pattern: PatternRand, Seed: 299792458, Unique: true}
+	first := make([]byte, 1024*1024)
+	second := make([]byte, 1024*1024)
+
+	_, _ = NewDataReaderFromConfig(65536, config).Read(first)
+	_, _ = NewDataReaderFromConfig(65536, config).Read(second)
+	if !bytes.Equal(first, second) {
+		t.Error("Readers with the same seed produced different data.")
+	}
+
+	config.Seed = deriveSeed(config.Seed, SeedWriterData, 0, 1)
+	_, _ = NewDataReaderFromConfig(65536, config).Read(second)
+	if bytes.Equal(first, second) {
+		t.Error("Readers with different seeds produced the same data.")
+	}
+
+	if deriveSeed(1, SeedWriterData, 0, 1) == deriveSeed(1, SeedWriterData, 1, 0) {
+		t.Error("Derived seeds for different workers are identical.")
+	}
+	if deriveSeed(1, SeedWriterData, 0, 1) != deriveSeed(1, SeedWriterData, 0, 1) {
+		t.Error("Derived seeds are not reproducible.")
+	}
+}
+
 func TestDataReaderSizeRange(t *testing.T) {
 	maxSize := 1024 * 1024
 	dataReader := NewDataReader(maxSize/2, PatternRand)
@@ -149,6 +212,82 @@ func TestNewDataReader(t *testing.T) {
 	}
 }
 
+func TestDataReaderCompression(t *testing.T) {
+	for _, target := range []float64{2, 3, 4} {
+		dataReader := NewDataReaderFromConfig(4*1024*1024, DataConfig{Compression: target, Pattern: PatternRand})
+		ratio := compressionRatio(dataReader.data)
+		t.Logf("Target %0.2f:1, measured %0.2f:1\n", target, ratio)
+		if math.Abs(ratio-target)/target > 0.15 {
+			t.Errorf("Compression ratio %0.2f:1 is not within 15%% of the %0.2f:1 target.\n", ratio, target)
+		}
+	}
+
+	if ratio := compressionRatio(NewDataReader(4*1024*1024, PatternRand).data); ratio > 1.05 {
+		t.Errorf("Random data compressed at %0.2f:1.\n", ratio)
+	}
+}
+
+func TestParseCompression(t *testing.T) {
+	if ratio, err := parseCompression("2:1"); err != nil || ratio != 2 {
+		t.Errorf("Failed to parse 2:1. %0.2f, %v\n", ratio, err)
+	}
+	if ratio, err := parseCompression("2.5"); err != nil || ratio != 2.5 {
+		t.Errorf("Failed to parse 2.5. %0.2f, %v\n", ratio, err)
+	}
+	if _, err := parseCompression("0.5:1"); err == nil {
+		t.Error("Accepted a compression ratio below 1:1.")
+	}
+	if _, err := parseCompression("two"); err == nil {
+		t.Error("Accepted a non-numeric compression ratio.")
+	}
+}
+
+func countUniqueBlocks(data []byte) int {
+	blocks := make(map[string]bool)
+	for start := 0; start+DedupeBlockSize <= len(data); start += DedupeBlockSize {
+		blocks[string(data[start:start+DedupeBlockSize])] = true
+	}
+	return len(blocks)
+}
+
+func TestDataReaderUnique(t *testing.T) {
+	// The output is 16 times larger than the buffer, so every block would repeat without the stamps.
+	data := make([]byte, 16*1024*1024)
+	totalBlocks := len(data) / DedupeBlockSize
+
+	dataReader := NewDataReaderFromConfig(1024*1024, DataConfig{Pattern: PatternRand, Unique: true})
+	dataReader.position = 0
+	_, _ = dataReader.Read(data)
+	if unique := countUniqueBlocks(data); unique != totalBlocks {
+		t.Errorf("Expected %d unique blocks, found %d.\n", totalBlocks, unique)
+	}
+}
+
+func TestDataReaderDedupe(t *testing.T) {
+	// One buffer length of output at the default buffer size, so nothing repeats by wrapping.
+	bufferSize := 32 * 1024 * 1024
+	totalBlocks := bufferSize / DedupeBlockSize
+	config := DataConfig{Dedupe: 0.5, DedupeSeed: 7, Pattern: PatternRand}
+
+	config.Seed = 1
+	first := make([]byte, bufferSize)
+	_, _ = NewDataReaderFromConfig(bufferSize, config).Read(first)
+	unique := float64(countUniqueBlocks(first)) / float64(totalBlocks)
+	t.Logf("Dedupe 0.5 produced %0.2f unique blocks\n", unique)
+	if unique < 0.45 || unique > 0.55 {
+		t.Errorf("Expected roughly half of the blocks to be unique, found %0.2f.\n", unique)
+	}
+
+	// A second writer shares the repeat pool, so its repeated blocks add nothing new.
+	config.Seed = 2
+	second := make([]byte, bufferSize)
+	_, _ = NewDataReaderFromConfig(bufferSize, config).Read(second)
+	combined := float64(countUniqueBlocks(append(first, second...))) / float64(2*totalBlocks)
+	if combined < 0.45 || combined > 0.55 {
+		t.Errorf("Expected roughly half of two writers' blocks to be unique, found %0.2f.\n", combined)
+	}
+}
+
 func BenchmarkDataReader_Read(b *testing.B) {
 	b.ReportAllocs()
 	data := make([]byte, 64*1024*1024)
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BurnInConfig describes a multi-pass write and verify run across every target file.
+type BurnInConfig struct {
+	BlockSize  int64
+	BufferSize int
+	Direct     bool
+	FileSize   int64
+	Files      []string
+	Patterns   []DataConfig
+	Seed       int64
+	StatePath  string
+}
+
+type burnInRange struct {
+	Start int64
+	End   int64
+}
+
+type burnInResult struct {
+	Failures    []burnInRange
+	Pass        int
+	Path        string
+	Pattern     DataConfig
+	VerifyBytes int64
+	VerifyTime  time.Duration
+	WriteBytes  int64
+	WriteTime   time.Duration
+}
+
+// parsePatternList converts a comma separated list of byte patterns into data configurations.
+func parsePatternList(list string) ([]DataConfig, error) {
+	var patterns []DataConfig
+
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		pattern, custom, err := parsePattern(name)
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, DataConfig{Custom: custom, Pattern: pattern})
+	}
+	if len(patterns) == 0 {
+		return nil, fmt.Errorf("no byte patterns specified")
+	}
+
+	return patterns, nil
+}
+
+func patternListName(patterns []DataConfig) string {
+	var names []string
+
+	for _, pattern := range patterns {
+		names = append(names, pattern.PatternName())
+	}
+	return strings.Join(names, ",")
+}
+
+// addFailure extends the last failing range when offset is contiguous with it, otherwise a new range is started.
+func addFailure(failures []burnInRange, offset int64, length int64) []burnInRange {
+	if len(failures) > 0 && failures[len(failures)-1].End == offset {
+		failures[len(failures)-1].End = offset + length
+		return failures
+	}
+	return append(failures, burnInRange{Start: offset, End: offset + length})
+}
+
+// readBurnInState returns the number of passes already completed for the pattern list in the state file.
+func readBurnInState(statePath string, patterns []DataConfig) int {
+	var completed int
+	var stateList string
+
+	stateData, err := os.ReadFile(statePath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("WARNING: Unable to read burn-in state %s. %s\n", statePath, err)
+		}
+		return 0
+	}
+
+	if _, err := fmt.Sscanf(string(stateData), "patterns %s\ncompleted %d\n", &stateList, &completed); err != nil {
+		log.Printf("WARNING: Burn-in state %s is malformed, starting from the first pass. %s\n", statePath, err)
+		return 0
+	}
+	if stateList != patternListName(patterns) {
+		log.Printf("WARNING: Burn-in state %s was recorded for patterns %s, starting from the first pass.\n", statePath, stateList)
+		return 0
+	}
+	if completed > len(patterns) {
+		completed = len(patterns)
+	}
+
+	return completed
+}
+
+func writeBurnInState(statePath string, patterns []DataConfig, completed int) error {
+	stateData := fmt.Sprintf("patterns %s\ncompleted %d\n", patternListName(patterns), completed)
+	return os.WriteFile(statePath, []byte(stateData), 0644)
+}
+
+// burnInWrite fills the whole target with the pattern held by dr, recording any ranges that fail to write.
+func burnInWrite(result *burnInResult, dr *dataReader, config *BurnInConfig, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	data := make([]byte, config.BlockSize)
+	size := targetSize(result.Path, config.FileSize)
+
+	workFile, err := os.OpenFile(result.Path, writerFlags(config.Direct), 0644)
+	if err != nil {
+		log.Printf("[Burn-in] ERROR: Unable to open %s for writing. %s\n", result.Path, err)
+		result.Failures = addFailure(result.Failures, 0, size)
+		return
+	}
+	defer func(workFile *os.File) {
+		if err := workFile.Close(); err != nil {
+			log.Printf("[Burn-in] ERROR: Unable to close file %s. %s\n", workFile.Name(), err)
+		}
+	}(workFile)
+
+	startTime := time.Now()
+	for offset := int64(0); offset < size; offset += config.BlockSize {
+		if Stop {
+			break
+		}
+		_, _ = dr.Read(data)
+
+		bytesNeeded := config.BlockSize
+		if size-offset < bytesNeeded {
+			bytesNeeded = size - offset
+		}
+
+		n, err := workFile.WriteAt(data[:bytesNeeded], offset)
+		result.WriteBytes += int64(n)
+		if err != nil {
+			if Verbose {
+				log.Printf("[Burn-in] %s: Write failed at offset %d. %s\n", result.Path, offset, err)
+			}
+			result.Failures = addFailure(result.Failures, offset, bytesNeeded)
+		}
+	}
+	if err := workFile.Sync(); err != nil {
+		log.Printf("[Burn-in] ERROR: Unable to sync %s. %s\n", result.Path, err)
+	}
+	result.WriteTime = time.Now().Sub(startTime)
+}
+
+// burnInVerify reads back the whole target and compares it with the pattern held by dr.
+func burnInVerify(result *burnInResult, dr *dataReader, config *BurnInConfig, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	data := make([]byte, config.BlockSize)
+	expected := make([]byte, config.BlockSize)
+	size := targetSize(result.Path, config.FileSize)
+
+	workFile, err := os.OpenFile(result.Path, readerFlags(config.Direct), 0644)
+	if err != nil {
+		log.Printf("[Burn-in] ERROR: Unable to open %s for reading. %s\n", result.Path, err)
+		result.Failures = addFailure(result.Failures, 0, size)
+		return
+	}
+	defer func(workFile *os.File) {
+		if err := workFile.Close(); err != nil {
+			log.Printf("[Burn-in] ERROR: Unable to close file %s. %s\n", workFile.Name(), err)
+		}
+	}(workFile)
+
+	startTime := time.Now()
+	for offset := int64(0); offset < size; offset += config.BlockSize {
+		if Stop {
+			break
+		}
+		_, _ = dr.Read(expected)
+
+		bytesNeeded := config.BlockSize
+		if size-offset < bytesNeeded {
+			bytesNeeded = size - offset
+		}
+
+		n, err := workFile.ReadAt(data[:bytesNeeded], offset)
+		result.VerifyBytes += int64(n)
+		if err != nil && err != io.EOF {
+			if Verbose {
+				log.Printf("[Burn-in] %s: Read failed at offset %d. %s\n", result.Path, offset, err)
+			}
+			result.Failures = addFailure(result.Failures, offset, bytesNeeded)
+			continue
+		}
+		if int64(n) < bytesNeeded || !bytes.Equal(data[:bytesNeeded], expected[:bytesNeeded]) {
+			result.Failures = addFailure(result.Failures, offset, bytesNeeded)
+		}
+	}
+	result.VerifyTime = time.Now().Sub(startTime)
+}
+
+// burnIn walks every target through each pattern with a full write pass followed by a full verify pass.
+// Completed passes are recorded in the state file, if one is configured, so an interrupted run can resume.
+func burnIn(config *BurnInConfig) [][]*burnInResult {
+	var results [][]*burnInResult
+	var wg sync.WaitGroup
+
+	firstPass := 0
+	if config.StatePath != "" {
+		firstPass = readBurnInState(config.StatePath, config.Patterns)
+		if firstPass > 0 {
+			log.Printf("Resuming burn-in after %d of %d completed passes.\n", firstPass, len(config.Patterns))
+		}
+	}
+
+	for pass := firstPass; pass < len(config.Patterns); pass++ {
+		var passResults []*burnInResult
+		var readers []*dataReader
+
+		pattern := config.Patterns[pass]
+		log.Printf("Burn-in pass %d/%d: writing pattern %s\n", pass+1, len(config.Patterns), pattern.PatternName())
+		for fileIndex, f := range config.Files {
+			// Every target starts at the beginning of its own buffer, so the
+			// verify pass can regenerate the expected data from the same buffer.
+			pattern.Seed = deriveSeed(config.Seed, SeedBurnIn, int64(pass), int64(fileIndex))
+			dr := NewDataReaderFromConfig(config.BufferSize, pattern)
+			dr.position = 0
+			readers = append(readers, dr)
+
+			result := &burnInResult{Pass: pass + 1, Path: f, Pattern: pattern}
+			passResults = append(passResults, result)
+			wg.Add(1)
+			go burnInWrite(result, dr, config, &wg)
+		}
+		wg.Wait()
+		if Stop {
+			break
+		}
+
+		dropPageCache()
+
+		log.Printf("Burn-in pass %d/%d: verifying pattern %s\n", pass+1, len(config.Patterns), pattern.PatternName())
+		for i, result := range passResults {
+			wg.Add(1)
+			go burnInVerify(result, &dataReader{data: readers[i].data}, config, &wg)
+		}
+		wg.Wait()
+		if Stop {
+			break
+		}
+
+		results = append(results, passResults)
+		if config.StatePath != "" {
+			if err := writeBurnInState(config.StatePath, config.Patterns, pass+1); err != nil {
+				log.Printf("ERROR: Unable to save burn-in state %s. %s\n", config.StatePath, err)
+			}
+		}
+	}
+
+	return results
+}
+
+func (r *burnInResult) String() string {
+	var output string
+
+	output += fmt.Sprintf(
+		"  %s: write %0.2f MiB/sec, verify %0.2f MiB/sec, %d failing ranges\n",
+		r.Path,
+		float64(r.WriteBytes)/MiB/r.WriteTime.Seconds(),
+		float64(r.VerifyBytes)/MiB/r.VerifyTime.Seconds(),
+		len(r.Failures),
+	)
+	for _, failure := range r.Failures {
+		output += fmt.Sprintf("    %d-%d (%s)\n", failure.Start, failure.End, humanizeSize(float64(failure.End-failure.Start), true))
+	}
+	return output
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"path"
+	"testing"
+)
+
+func TestAddFailure(t *testing.T) {
+	var failures []burnInRange
+
+	failures = addFailure(failures, 0, 4096)
+	failures = addFailure(failures, 4096, 4096)
+	failures = addFailure(failures, 16384, 4096)
+	if len(failures) != 2 {
+		t.Fatalf("Expected 2 failing ranges, found %d.\n", len(failures))
+	}
+	if failures[0].Start != 0 || failures[0].End != 8192 {
+		t.Errorf("Contiguous failures were not merged. %d-%d\n", failures[0].Start, failures[0].End)
+	}
+	if failures[1].Start != 16384 || failures[1].End != 20480 {
+		t.Errorf("Separate failure range is incorrect. %d-%d\n", failures[1].Start, failures[1].End)
+	}
+}
+
+func TestBurnInState(t *testing.T) {
+	statePath := path.Join(t.TempDir(), "burnin.state")
+	patterns, err := parsePatternList("AA, 55,ff,zero,random")
+	if err != nil {
+		t.Fatalf("Unable to parse pattern list. %s\n", err)
+	}
+
+	if completed := readBurnInState(statePath, patterns); completed != 0 {
+		t.Errorf("Missing state file reported %d completed passes.\n", completed)
+	}
+	if err := writeBurnInState(statePath, patterns, 3); err != nil {
+		t.Fatalf("Unable to write state file. %s\n", err)
+	}
+	if completed := readBurnInState(statePath, patterns); completed != 3 {
+		t.Errorf("Expected 3 completed passes, read %d.\n", completed)
+	}
+	if completed := readBurnInState(statePath, patterns[1:]); completed != 0 {
+		t.Errorf("State for a different pattern list reported %d completed passes.\n", completed)
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync/atomic"
+)
+
+// ConsistencyTable holds the last completed write generation for every block of every file.
+// Writers publish generations after their writes return, and readers compare the generation
+// stamped in each block they read with the one published before their read started.
+type ConsistencyTable struct {
+	BlockSize  int64
+	files      map[string][]consistencyBlock
+	generation uint64
+}
+
+type consistencyBlock struct {
+	generation uint64
+	overlapped uint64 // Generation which an older, overlapping write completed after
+	pending    int32  // Writes issued to the block which have not completed
+}
+
+// ConsistencyResult counts the reads a reader checked and the violations it found.
+type ConsistencyResult struct {
+	Checked    int64
+	Racing     int64 // Reads not checked because a write to the block was in progress or overlapped another
+	Missing    int64 // Reads of a block with a completed write which held no block header
+	Stale      int64 // Reads which returned a generation older than the last completed write
+	Torn       int64 // Reads whose checksum did not match, typically racing an in-progress write
+	Violations []string
+}
+
+func NewConsistencyTable(blockSize int64) *ConsistencyTable {
+	return &ConsistencyTable{BlockSize: blockSize, files: make(map[string][]consistencyBlock)}
+}
+
+// Add tracks a file of size bytes. Files must be added before any reader or writer starts.
+func (t *ConsistencyTable) Add(filePath string, size int64) {
+	if _, ok := t.files[filePath]; ok {
+		return
+	}
+	t.files[filePath] = make([]consistencyBlock, (size+t.BlockSize-1)/t.BlockSize)
+}
+
+// NextGeneration returns a new generation, greater than every generation returned before it.
+func (t *ConsistencyTable) NextGeneration() uint64 {
+	return atomic.AddUint64(&t.generation, 1)
+}
+
+func (t *ConsistencyTable) block(filePath string, offset int64) *consistencyBlock {
+	blocks := t.files[filePath]
+	index := offset / t.BlockSize
+	if offset%t.BlockSize != 0 || index < 0 || index >= int64(len(blocks)) {
+		return nil
+	}
+	return &blocks[index]
+}
+
+// Begin marks a write to the block at offset as in progress. Writers call it before taking the write's
+// generation, so every write completed before Begin holds an older generation.
+func (t *ConsistencyTable) Begin(filePath string, offset int64) {
+	if block := t.block(filePath, offset); block != nil {
+		atomic.AddInt32(&block.pending, 1)
+	}
+}
+
+// Complete publishes generation as completed for the block at offset, keeping the highest completed
+// generation. A write completing with an older generation overlapped the newer one, so either may be
+// the block's content, and the block is marked overlapped until a newer write completes.
+func (t *ConsistencyTable) Complete(filePath string, offset int64, generation uint64) {
+	if block := t.block(filePath, offset); block != nil {
+		for {
+			current := atomic.LoadUint64(&block.generation)
+			if generation > current {
+				if atomic.CompareAndSwapUint64(&block.generation, current, generation) {
+					break
+				}
+				continue
+			}
+			if generation < current {
+				atomic.StoreUint64(&block.overlapped, current)
+				if atomic.LoadUint64(&block.generation) != current {
+					continue
+				}
+			}
+			break
+		}
+		atomic.AddInt32(&block.pending, -1)
+	}
+}
+
+// Completed returns the highest generation completed for the block at offset, or zero if none has, and
+// whether the block's content is uncertain. An in-progress write may land at any moment, and overlapping
+// writes may land in either order, so a read racing them can not be checked.
+func (t *ConsistencyTable) Completed(filePath string, offset int64) (uint64, bool) {
+	block := t.block(filePath, offset)
+	if block == nil {
+		return 0, false
+	}
+	racing := atomic.LoadInt32(&block.pending) > 0
+	generation := atomic.LoadUint64(&block.generation)
+	return generation, racing || atomic.LoadUint64(&block.overlapped) == generation && generation != 0
+}
+
+// Check compares a block read from offset with the generation completed before the read started.
+func (r *ConsistencyResult) Check(id int, filePath string, offset int64, expected uint64, racing bool, data []byte) {
+	if expected == 0 {
+		// Nothing has been written to this block yet, so any content is acceptable.
+		return
+	}
+	if racing {
+		r.Racing++
+		return
+	}
+	r.Checked++
+
+	header, valid, intact := readBlockHeader(data)
+	switch {
+	case !valid || header.Offset != offset:
+		r.Missing++
+		r.violation(id, "%s@%d: expected generation %d, read a block without a header", filePath, offset, expected)
+	case header.Generation < expected:
+		r.Stale++
+		r.violation(id, "%s@%d: expected generation %d, read stale generation %d", filePath, offset, expected, header.Generation)
+	case !intact:
+		r.Torn++
+	}
+}
+
+func (r *ConsistencyResult) violation(id int, format string, a ...interface{}) {
+	message := fmt.Sprintf(format, a...)
+	if Verbose {
+		log.Printf("[Reader %d] Consistency violation: %s\n", id, message)
+	}
+	if len(r.Violations) < 10 {
+		r.Violations = append(r.Violations, message)
+	}
+}
+
+func (r *ConsistencyResult) String() string {
+	output := fmt.Sprintf("%d reads checked, %d stale, %d missing, %d torn, %d racing writes", r.Checked, r.Stale, r.Missing, r.Torn, r.Racing)
+	for _, v := range r.Violations {
+		output += fmt.Sprintf("\n    %s", v)
+	}
+	return output
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestConsistencyCheck(t *testing.T) {
+	var result ConsistencyResult
+	table := NewConsistencyTable(4096)
+	table.Add("data", 4*4096)
+	block := make([]byte, 4096)
+
+	if expected, racing := table.Completed("data", 4096); expected != 0 || racing {
+		t.Errorf("Unwritten block reported generation %d, racing %v.\n", expected, racing)
+	}
+
+	table.Begin("data", 4096)
+	first := table.NextGeneration()
+	if _, racing := table.Completed("data", 4096); !racing {
+		t.Error("Block with a write in progress is not racing.")
+	}
+	table.Complete("data", 4096, first)
+	stampBlock(block, 4096, first)
+
+	table.Begin("data", 4096)
+	second := table.NextGeneration()
+	table.Complete("data", 4096, second)
+
+	expected, racing := table.Completed("data", 4096)
+	if expected != second || racing {
+		t.Fatalf("Expected generation %d without a racing write, found %d, %v.\n", second, expected, racing)
+	}
+
+	// The block still holds the first generation, so the read is stale.
+	result.Check(0, "data", 4096, expected, racing, block)
+	stampBlock(block, 4096, second)
+	result.Check(0, "data", 4096, expected, racing, block)
+	result.Check(0, "data", 4096, expected, racing, make([]byte, 4096))
+	result.Check(0, "data", 4096, expected, true, make([]byte, 4096))
+	if result.Checked != 3 || result.Stale != 1 || result.Missing != 1 || result.Racing != 1 {
+		t.Errorf("Unexpected consistency results. %s\n", &result)
+	}
+}
+
+func TestConsistencyOverlappingWrites(t *testing.T) {
+	table := NewConsistencyTable(4096)
+	table.Add("data", 4096)
+
+	// The older write completes last, so the newer generation is kept and the content is uncertain.
+	table.Begin("data", 0)
+	older := table.NextGeneration()
+	table.Begin("data", 0)
+	newer := table.NextGeneration()
+	table.Complete("data", 0, newer)
+	// The older write began before the newer one took its generation, so it is still in progress.
+	if _, racing := table.Completed("data", 0); !racing {
+		t.Error("Block with an older write in progress is not racing.")
+	}
+	table.Complete("data", 0, older)
+	if expected, racing := table.Completed("data", 0); expected != newer || !racing {
+		t.Errorf("Expected generation %d with overlapping writes, found %d, %v.\n", newer, expected, racing)
+	}
+
+	// A later write settles the block again.
+	table.Begin("data", 0)
+	latest := table.NextGeneration()
+	table.Complete("data", 0, latest)
+	if expected, racing := table.Completed("data", 0); expected != latest || racing {
+		t.Errorf("Expected generation %d without a racing write, found %d, %v.\n", latest, expected, racing)
+	}
+}
+
+func TestConsistencyRacingWriters(t *testing.T) {
+	var (
+		device  = make([]byte, 4096)
+		lock    sync.Mutex
+		result  ConsistencyResult
+		stop    int32
+		writers sync.WaitGroup
+	)
+	table := NewConsistencyTable(4096)
+	table.Add("data", 4096)
+
+	// Two writers follow the writer's protocol on the same block while a reader checks its reads.
+	for i := 0; i < 2; i++ {
+		writers.Add(1)
+		go func() {
+			defer writers.Done()
+			block := make([]byte, 4096)
+			for atomic.LoadInt32(&stop) == 0 {
+				table.Begin("data", 0)
+				generation := table.NextGeneration()
+				stampBlock(block, 0, generation)
+				runtime.Gosched()
+				lock.Lock()
+				copy(device, block)
+				lock.Unlock()
+				table.Complete("data", 0, generation)
+				runtime.Gosched()
+			}
+		}()
+	}
+
+	data := make([]byte, 4096)
+	for i := 0; i < 100000; i++ {
+		expected, racing := table.Completed("data", 0)
+		lock.Lock()
+		copy(data, device)
+		lock.Unlock()
+		result.Check(0, "data", 0, expected, racing, data)
+		runtime.Gosched()
+	}
+	atomic.StoreInt32(&stop, 1)
+	writers.Wait()
+
+	if result.Stale != 0 || result.Missing != 0 || result.Torn != 0 {
+		t.Errorf("Racing writers produced consistency violations. %s\n", &result)
+	}
+	t.Logf("%s\n", &result)
+}
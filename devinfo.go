@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DeviceInfo is a snapshot of a block device's identity and queue settings, which determine how its
+// results should be read.
+type DeviceInfo struct {
+	Capacity          int64             `json:"capacity_bytes"`
+	Device            string            `json:"device"`
+	Firmware          string            `json:"firmware"`
+	LogicalBlockSize  int64             `json:"logical_block_size"`
+	MaxSectorsKB      int64             `json:"max_sectors_kb"`
+	Model             string            `json:"model"`
+	NrRequests        int64             `json:"nr_requests"`
+	OptimalIOSize     int64             `json:"optimal_io_size"`
+	PhysicalBlockSize int64             `json:"physical_block_size"`
+	Queue             map[string]string `json:"queue"` // Every readable attribute of the device's queue directory
+	ReadAheadKB       int64             `json:"read_ahead_kb"`
+	Rotational        bool              `json:"rotational"`
+	Scheduler         string            `json:"scheduler"` // The active scheduler
+	Vendor            string            `json:"vendor"`
+	WriteCache        string            `json:"write_cache"`
+}
+
+// readSysfsValue returns the trimmed content of a sysfs attribute, or an empty string when it is missing.
+func readSysfsValue(elem ...string) string {
+	data, err := os.ReadFile(path.Join(append([]string{SysRoot}, elem...)...))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// bracketedChoice returns the selected option of a sysfs list, which is in brackets, such as bfq from
+// "mq-deadline kyber [bfq] none".
+func bracketedChoice(schedulers string) string {
+	for _, s := range strings.Fields(schedulers) {
+		if strings.HasPrefix(s, "[") && strings.HasSuffix(s, "]") {
+			return strings.Trim(s, "[]")
+		}
+	}
+	return schedulers
+}
+
+// readDeviceInfo snapshots the identity and queue settings of device from sysfs. Attributes the device
+// does not provide are left empty.
+func readDeviceInfo(device string) (*DeviceInfo, error) {
+	entries, err := os.ReadDir(path.Join(SysRoot, "block", device, "queue"))
+	if err != nil {
+		return nil, err
+	}
+
+	info := &DeviceInfo{Device: device, Queue: make(map[string]string)}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		if data, err := os.ReadFile(path.Join(SysRoot, "block", device, "queue", entry.Name())); err == nil {
+			// Some attributes, such as those of the scheduler, are write only.
+			info.Queue[entry.Name()] = strings.TrimSpace(string(data))
+		}
+	}
+
+	number := func(name string) int64 {
+		value, _ := strconv.ParseInt(info.Queue[name], 10, 64)
+		return value
+	}
+	info.LogicalBlockSize = number("logical_block_size")
+	info.MaxSectorsKB = number("max_sectors_kb")
+	info.NrRequests = number("nr_requests")
+	info.OptimalIOSize = number("optimal_io_size")
+	info.PhysicalBlockSize = number("physical_block_size")
+	info.ReadAheadKB = number("read_ahead_kb")
+	info.Rotational = info.Queue["rotational"] == "1"
+	info.Scheduler = bracketedChoice(info.Queue["scheduler"])
+	info.WriteCache = info.Queue["write_cache"]
+
+	// NVMe controllers report firmware_rev, and SCSI devices rev.
+	info.Model = readSysfsValue("block", device, "device", "model")
+	info.Vendor = readSysfsValue("block", device, "device", "vendor")
+	if info.Firmware = readSysfsValue("block", device, "device", "firmware_rev"); info.Firmware == "" {
+		info.Firmware = readSysfsValue("block", device, "device", "rev")
+	}
+	if sectors, err := strconv.ParseInt(readSysfsValue("block", device, "size"), 10, 64); err == nil {
+		info.Capacity = sectors * SectorSize
+	}
+	return info, nil
+}
+
+// Csv returns every attribute of the snapshot, with the queue attributes prefixed by "queue/".
+func (d *DeviceInfo) Csv() string {
+	output := "\"attribute\",\"value\"\n"
+	for _, attr := range [][2]string{
+		{"device", d.Device},
+		{"model", d.Model},
+		{"vendor", d.Vendor},
+		{"firmware", d.Firmware},
+		{"capacity bytes", strconv.FormatInt(d.Capacity, 10)},
+	} {
+		output += fmt.Sprintf("\"%s\",\"%s\"\n", attr[0], attr[1])
+	}
+
+	var names []string
+	for name := range d.Queue {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		output += fmt.Sprintf("\"queue/%s\",\"%s\"\n", name, strings.ReplaceAll(d.Queue[name], "\"", "\"\""))
+	}
+	return output
+}
+
+func (d *DeviceInfo) String() string {
+	var identity []string
+	for _, value := range []string{d.Vendor, d.Model} {
+		if value != "" {
+			identity = append(identity, value)
+		}
+	}
+	if len(identity) == 0 {
+		identity = append(identity, "unknown model")
+	}
+	if d.Firmware != "" {
+		identity = append(identity, "firmware "+d.Firmware)
+	}
+	rotational := "non-rotational"
+	if d.Rotational {
+		rotational = "rotational"
+	}
+	return fmt.Sprintf(
+		"%s: %s, %s, %s\n  Scheduler: %s, nr_requests: %d, block size: %d logical, %d physical, optimal IO: %d, max_sectors_kb: %d, read_ahead_kb: %d, write cache: %s\n",
+		d.Device, strings.Join(identity, " "), humanizeSize(float64(d.Capacity), true), rotational,
+		d.Scheduler, d.NrRequests, d.LogicalBlockSize, d.PhysicalBlockSize, d.OptimalIOSize, d.MaxSectorsKB, d.ReadAheadKB, d.WriteCache,
+	)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestDeviceInfo(t *testing.T) {
+	root := fakeRoot(t, map[string]string{
+		"sys/block/nvme0n1/size":                      "7814037168\n",
+		"sys/block/nvme0n1/queue/scheduler":           "[none] mq-deadline kyber\n",
+		"sys/block/nvme0n1/queue/nr_requests":         "1023\n",
+		"sys/block/nvme0n1/queue/rotational":          "0\n",
+		"sys/block/nvme0n1/queue/logical_block_size":  "512\n",
+		"sys/block/nvme0n1/queue/physical_block_size": "4096\n",
+		"sys/block/nvme0n1/queue/optimal_io_size":     "0\n",
+		"sys/block/nvme0n1/queue/max_sectors_kb":      "128\n",
+		"sys/block/nvme0n1/queue/write_cache":         "write back\n",
+		"sys/block/nvme0n1/queue/read_ahead_kb":       "128\n",
+		"sys/block/nvme0n1/device/model":              "Example NVMe 4TB  \n",
+		"sys/block/nvme0n1/device/firmware_rev":       "1B2QEXM7\n",
+	})
+	if err := os.MkdirAll(path.Join(root, "sys", "block", "nvme0n1", "queue", "iosched"), 0755); err != nil {
+		t.Fatalf("Unable to create fake device. %s\n", err)
+	}
+
+	info, err := readDeviceInfo("nvme0n1")
+	if err != nil {
+		t.Fatalf("Unable to read device info. %s\n", err)
+	}
+	if info.Scheduler != "none" || info.NrRequests != 1023 || info.Rotational || info.PhysicalBlockSize != 4096 || info.WriteCache != "write back" {
+		t.Errorf("Unexpected queue settings %+v\n", info)
+	}
+	if info.Model != "Example NVMe 4TB" || info.Firmware != "1B2QEXM7" || info.Vendor != "" || info.Capacity != 7814037168*512 {
+		t.Errorf("Unexpected device identity %+v\n", info)
+	}
+	if _, ok := info.Queue["iosched"]; ok || len(info.Queue) != 9 {
+		t.Errorf("Unexpected queue attributes %v\n", info.Queue)
+	}
+	if csv := info.Csv(); !strings.Contains(csv, "\"firmware\",\"1B2QEXM7\"\n") || !strings.HasSuffix(csv, "\"queue/write_cache\",\"write back\"\n") {
+		t.Errorf("Unexpected device CSV %q\n", csv)
+	}
+
+	if line := strings.Split(info.String(), "\n")[0]; line != "nvme0n1: Example NVMe 4TB firmware 1B2QEXM7, 3.64 TB, non-rotational" {
+		t.Errorf("Unexpected device summary %q\n", line)
+	}
+
+	s := &SysStatsCollection{}
+	s.Add("nvme0n1")
+	s.Add("sdz")
+	if s.Disk[0].Info == nil || s.Disk[1].Info != nil {
+		t.Errorf("Expected device info only for nvme0n1\n")
+	}
+}
+
+func TestBracketedChoice(t *testing.T) {
+	for list, expected := range map[string]string{"mq-deadline kyber [bfq] none": "bfq", "none": "none", "": ""} {
+		if s := bracketedChoice(list); s != expected {
+			t.Errorf("Expected %q from %q, found %q\n", expected, list, s)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// Environment describes the host a run was performed on, so results can be compared across machines
+// and kernel settings.
+type Environment struct {
+	CPUModel        string             `json:"cpu_model"`
+	CPUs            int                `json:"cpus"`
+	Kernel          string             `json:"kernel"`
+	Memory          int64              `json:"memory_bytes"`
+	Mounts          []*EnvironmentPath `json:"mounts"`
+	OS              string             `json:"os"`
+	Sysctls         map[string]string  `json:"sysctls"`              // The vm.dirty_* sysctls which govern page cache writeback
+	TransparentHuge map[string]string  `json:"transparent_hugepage"` // Transparent hugepage settings
+}
+
+// EnvironmentPath is the filesystem holding a tested path.
+type EnvironmentPath struct {
+	Device     string `json:"device"`
+	Filesystem string `json:"filesystem"`
+	MountPoint string `json:"mount_point"`
+	Options    string `json:"options"`
+	Path       string `json:"path"`
+}
+
+// readEnvironment describes the host and the filesystem of each path in paths. Details the host does not
+// provide are left empty.
+func readEnvironment(paths []string) *Environment {
+	env := &Environment{
+		CPUs:            runtime.NumCPU(),
+		Kernel:          readProcValue("sys", "kernel", "osrelease"),
+		Mounts:          []*EnvironmentPath{},
+		OS:              runtime.GOOS,
+		Sysctls:         make(map[string]string),
+		TransparentHuge: make(map[string]string),
+	}
+	env.CPUModel = readCPUModel()
+	if values, err := readMemInfo(); err == nil {
+		env.Memory = values["MemTotal"]
+	}
+
+	if entries, err := os.ReadDir(path.Join(ProcRoot, "sys", "vm")); err == nil {
+		for _, entry := range entries {
+			if strings.HasPrefix(entry.Name(), "dirty") {
+				env.Sysctls["vm."+entry.Name()] = readProcValue("sys", "vm", entry.Name())
+			}
+		}
+	}
+	for _, name := range []string{"enabled", "defrag"} {
+		if value := readSysfsValue("kernel", "mm", "transparent_hugepage", name); value != "" {
+			env.TransparentHuge[name] = bracketedChoice(value)
+		}
+	}
+
+	mounts := readMounts()
+	for _, p := range paths {
+		env.Mounts = append(env.Mounts, mountForPath(mounts, p))
+	}
+	return env
+}
+
+// readProcValue returns the trimmed content of a procfs file, or an empty string when it is missing.
+func readProcValue(elem ...string) string {
+	data, err := os.ReadFile(path.Join(append([]string{ProcRoot}, elem...)...))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// readCPUModel returns the model name of the first CPU in /proc/cpuinfo.
+func readCPUModel() string {
+	data, err := os.ReadFile(path.Join(ProcRoot, "cpuinfo"))
+	if err != nil {
+		return ""
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		// x86 reports "model name", while some arm and ppc kernels report "Hardware" or "cpu".
+		switch strings.TrimSpace(name) {
+		case "model name", "Hardware", "cpu":
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
+
+// readMounts returns the entries of /proc/self/mounts, with the octal escapes of mount points decoded.
+func readMounts() []*EnvironmentPath {
+	var mounts []*EnvironmentPath
+
+	mountsFile, err := os.Open(path.Join(ProcRoot, "self", "mounts"))
+	if err != nil {
+		return nil
+	}
+	defer mountsFile.Close()
+
+	scanner := bufio.NewScanner(mountsFile)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 {
+			continue
+		}
+		mounts = append(mounts, &EnvironmentPath{
+			Device:     fields[0],
+			Filesystem: fields[2],
+			MountPoint: strings.NewReplacer(`\040`, " ", `\011`, "\t", `\012`, "\n", `\134`, `\`).Replace(fields[1]),
+			Options:    fields[3],
+		})
+	}
+	return mounts
+}
+
+// mountForPath returns the mount holding p, which is the last mounted of the longest mount points
+// containing it. Block devices are not mounted, so only their path is returned.
+func mountForPath(mounts []*EnvironmentPath, p string) *EnvironmentPath {
+	if isBlockDevice(p) {
+		return &EnvironmentPath{Device: p, Filesystem: "block device", Path: p}
+	}
+	abs := p
+	if resolved, err := filepath.Abs(p); err == nil {
+		abs = resolved
+	}
+
+	var best *EnvironmentPath
+	for _, m := range mounts {
+		if abs != m.MountPoint && m.MountPoint != "/" && !strings.HasPrefix(abs, m.MountPoint+"/") {
+			continue
+		}
+		if best == nil || len(m.MountPoint) >= len(best.MountPoint) {
+			best = m
+		}
+	}
+	if best == nil {
+		return &EnvironmentPath{Filesystem: "unknown filesystem", Path: p}
+	}
+	found := *best
+	found.Path = p
+	return &found
+}
+
+func (e *Environment) String() string {
+	output := fmt.Sprintf(
+		"Environment:\n  Kernel: %s %s\n  CPU: %s, %d CPUs\n  Memory: %s\n",
+		e.OS, e.Kernel, e.CPUModel, e.CPUs, humanizeSize(float64(e.Memory), true),
+	)
+	for _, m := range e.Mounts {
+		if m.MountPoint == "" {
+			output += fmt.Sprintf("  %s: %s\n", m.Path, m.Filesystem)
+			continue
+		}
+		output += fmt.Sprintf("  %s: %s %s on %s (%s)\n", m.Path, m.Filesystem, m.Device, m.MountPoint, m.Options)
+	}
+
+	var names []string
+	for name := range e.Sysctls {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var sysctls []string
+	for _, name := range names {
+		sysctls = append(sysctls, fmt.Sprintf("%s=%s", name, e.Sysctls[name]))
+	}
+	if len(sysctls) > 0 {
+		output += fmt.Sprintf("  Sysctls: %s\n", strings.Join(sysctls, ", "))
+	}
+	if len(e.TransparentHuge) > 0 {
+		output += fmt.Sprintf("  Transparent hugepages: enabled %s, defrag %s\n", e.TransparentHuge["enabled"], e.TransparentHuge["defrag"])
+	}
+	return output
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEnvironment(t *testing.T) {
+	fakeRoot(t, map[string]string{
+		"proc/sys/kernel/osrelease":                  "6.1.0-test\n",
+		"proc/cpuinfo":                               "processor\t: 0\nmodel name\t: Example CPU @ 3.00GHz\n\nprocessor\t: 1\nmodel name\t: Example CPU @ 3.00GHz\n",
+		"proc/meminfo":                               "MemTotal:       16384 kB\n",
+		"proc/sys/vm/dirty_ratio":                    "20\n",
+		"proc/sys/vm/dirty_background_ratio":         "10\n",
+		"proc/sys/vm/swappiness":                     "60\n",
+		"sys/kernel/mm/transparent_hugepage/enabled": "always [madvise] never\n",
+		"proc/self/mounts": "/dev/sda1 / ext4 rw,relatime 0 0\n" +
+			"/dev/nvme0n1p1 /data xfs rw,noatime,logbufs=8 0 0\n" +
+			"/dev/sdb1 /data\\040two ext4 rw,nodelalloc 0 0\n",
+	})
+
+	env := readEnvironment([]string{"/data/scriba", "/data two", "/database"})
+	if env.Kernel != "6.1.0-test" || env.CPUModel != "Example CPU @ 3.00GHz" || env.Memory != 16*MiB {
+		t.Errorf("Unexpected host environment %+v\n", env)
+	}
+	if len(env.Sysctls) != 2 || env.Sysctls["vm.dirty_ratio"] != "20" || env.TransparentHuge["enabled"] != "madvise" {
+		t.Errorf("Unexpected kernel settings %v %v\n", env.Sysctls, env.TransparentHuge)
+	}
+	expected := []struct{ fs, mount, options string }{
+		{"xfs", "/data", "rw,noatime,logbufs=8"},
+		{"ext4", "/data two", "rw,nodelalloc"},
+		// /database is not below /data.
+		{"ext4", "/", "rw,relatime"},
+	}
+	for i, e := range expected {
+		if m := env.Mounts[i]; m.Filesystem != e.fs || m.MountPoint != e.mount || m.Options != e.options {
+			t.Errorf("Unexpected mount for %s %+v\n", m.Path, m)
+		}
+	}
+
+	output := env.String()
+	for _, line := range []string{
+		"  /data/scriba: xfs /dev/nvme0n1p1 on /data (rw,noatime,logbufs=8)\n",
+		"  Sysctls: vm.dirty_background_ratio=10, vm.dirty_ratio=20\n",
+	} {
+		if !strings.Contains(output, line) {
+			t.Errorf("Expected %q in %q\n", line, output)
+		}
+	}
+}
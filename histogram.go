@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"time"
+)
+
+const (
+	HistogramMaxValue  = time.Hour // Latencies above this are recorded as this value
+	HistogramPrecision = 3         // Default number of significant decimal digits
+)
+
+// Histogram records latencies in fixed memory using log-linear buckets, in the style of HdrHistogram.
+// Values are grouped into power of two ranges, and each range is split into linear sub-buckets, so every
+// recorded value is accurate to the configured number of significant digits.
+type Histogram struct {
+	count         int64
+	counts        []int64
+	max           time.Duration
+	min           time.Duration
+	precision     int
+	subBucketBits uint
+	subBucketHalf int64
+	sum           time.Duration
+}
+
+// NewHistogram creates a histogram accurate to precision significant decimal digits, from 1 to 5.
+func NewHistogram(precision int) *Histogram {
+	if precision < 1 {
+		precision = 1
+	}
+	if precision > 5 {
+		precision = 5
+	}
+
+	// Enough linear sub-buckets to distinguish 2 * 10^precision values in every power of two range.
+	subBucketBits := uint(math.Ceil(math.Log2(2 * math.Pow10(precision))))
+	h := &Histogram{
+		precision:     precision,
+		subBucketBits: subBucketBits,
+		subBucketHalf: 1 << (subBucketBits - 1),
+	}
+	h.counts = make([]int64, h.index(int64(HistogramMaxValue))+1)
+
+	return h
+}
+
+// index returns the position in counts of the bucket holding v.
+func (h *Histogram) index(v int64) int {
+	subBucketMask := uint64(h.subBucketHalf<<1 - 1)
+	bucket := 64 - bits.LeadingZeros64(uint64(v)|subBucketMask) - int(h.subBucketBits)
+	subBucket := v >> uint(bucket)
+
+	return int(int64(bucket)*h.subBucketHalf + subBucket)
+}
+
+// lowest returns the smallest value recorded in the bucket at index.
+func (h *Histogram) lowest(index int) int64 {
+	bucket := int64(index)/h.subBucketHalf - 1
+	subBucket := int64(index)%h.subBucketHalf + h.subBucketHalf
+	if bucket < 0 {
+		bucket = 0
+		subBucket -= h.subBucketHalf
+	}
+	return subBucket << uint(bucket)
+}
+
+// highest returns the largest value recorded in the bucket at index.
+func (h *Histogram) highest(index int) int64 {
+	bucket := int64(index)/h.subBucketHalf - 1
+	if bucket < 0 {
+		bucket = 0
+	}
+	return h.lowest(index) + (int64(1) << uint(bucket)) - 1
+}
+
+func (h *Histogram) Record(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	if h.count == 0 || d < h.min {
+		h.min = d
+	}
+	if d > h.max {
+		h.max = d
+	}
+	h.count++
+	h.sum += d
+
+	if d > HistogramMaxValue {
+		d = HistogramMaxValue
+	}
+	h.counts[h.index(int64(d))]++
+}
+
+// Merge adds every value recorded in o to h. Both histograms must have the same precision.
+func (h *Histogram) Merge(o *Histogram) error {
+	if o == nil || o.count == 0 {
+		return nil
+	}
+	if o.precision != h.precision {
+		return fmt.Errorf("unable to merge a histogram of precision %d into precision %d", o.precision, h.precision)
+	}
+
+	if h.count == 0 || o.min < h.min {
+		h.min = o.min
+	}
+	if o.max > h.max {
+		h.max = o.max
+	}
+	h.count += o.count
+	h.sum += o.sum
+	for i, c := range o.counts {
+		h.counts[i] += c
+	}
+	return nil
+}
+
+// Reset discards every recorded value.
+func (h *Histogram) Reset() {
+	for i := range h.counts {
+		h.counts[i] = 0
+	}
+	h.count = 0
+	h.max = 0
+	h.min = 0
+	h.sum = 0
+}
+
+func (h *Histogram) Count() int64 {
+	return h.count
+}
+
+func (h *Histogram) Max() time.Duration {
+	return h.max
+}
+
+func (h *Histogram) Mean() time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	return h.sum / time.Duration(h.count)
+}
+
+func (h *Histogram) Min() time.Duration {
+	return h.min
+}
+
+// Percentile returns the value below which the fraction q, from 0 to 1, of recorded values fall.
+func (h *Histogram) Percentile(q float64) time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+
+	target := int64(math.Ceil(q * float64(h.count)))
+	if target < 1 {
+		target = 1
+	}
+
+	var total int64
+	for i, c := range h.counts {
+		total += c
+		if total >= target {
+			v := time.Duration(h.highest(i))
+			if v > h.max || total == h.count {
+				// The highest bucket holds the maximum, which is also exact for clamped values.
+				return h.max
+			}
+			if v < h.min {
+				return h.min
+			}
+			return v
+		}
+	}
+	return h.max
+}
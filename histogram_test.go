@@ -0,0 +1,79 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestHistogramPercentile(t *testing.T) {
+	var values []time.Duration
+	h := NewHistogram(3)
+
+	// Log-uniform values from 1us to roughly 10s exercise many power of two ranges.
+	for i := 0; i < 100000; i++ {
+		v := time.Duration(1000 * math.Pow(10, 7*float64(i)/100000))
+		values = append(values, v)
+		h.Record(v)
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+
+	if h.Count() != int64(len(values)) {
+		t.Errorf("Expected %d values, counted %d.\n", len(values), h.Count())
+	}
+	if h.Min() != values[0] || h.Max() != values[len(values)-1] {
+		t.Errorf("Min %s and max %s do not match %s and %s.\n", h.Min(), h.Max(), values[0], values[len(values)-1])
+	}
+	for _, q := range []float64{0.5, 0.9, 0.99, 0.999, 0.9999} {
+		exact := values[int(math.Ceil(q*float64(len(values))))-1]
+		if err := math.Abs(float64(h.Percentile(q)-exact)) / float64(exact); err > 0.001 {
+			t.Errorf("P%g %s differs from %s by %0.4f.\n", q*100, h.Percentile(q), exact, err)
+		}
+	}
+}
+
+func TestHistogramMerge(t *testing.T) {
+	first := NewHistogram(2)
+	second := NewHistogram(2)
+	for i := 1; i <= 100; i++ {
+		first.Record(time.Duration(i) * time.Microsecond)
+		second.Record(time.Duration(i+100) * time.Microsecond)
+	}
+
+	if err := first.Merge(second); err != nil {
+		t.Fatalf("Unable to merge histograms. %s\n", err)
+	}
+	if first.Count() != 200 || first.Min() != time.Microsecond || first.Max() != 200*time.Microsecond {
+		t.Errorf("Merged histogram has count %d, min %s, max %s.\n", first.Count(), first.Min(), first.Max())
+	}
+	if first.Mean() != 100500*time.Nanosecond {
+		t.Errorf("Merged mean %s is not 100.5us.\n", first.Mean())
+	}
+	if err := first.Merge(NewHistogram(3)); err != nil {
+		t.Errorf("Merging an empty histogram failed. %s\n", err)
+	}
+
+	third := NewHistogram(3)
+	third.Record(time.Millisecond)
+	if err := first.Merge(third); err == nil {
+		t.Error("Merged histograms of different precision.")
+	}
+}
+
+func TestHistogramRange(t *testing.T) {
+	h := NewHistogram(HistogramPrecision)
+	h.Record(0)
+	h.Record(2 * HistogramMaxValue)
+
+	if h.Percentile(0) != 0 || h.Percentile(1) != 2*HistogramMaxValue {
+		t.Errorf("Out of range values were not clamped. %s, %s\n", h.Percentile(0), h.Percentile(1))
+	}
+}
+
+func BenchmarkHistogramRecord(b *testing.B) {
+	h := NewHistogram(HistogramPrecision)
+	for i := 0; i < b.N; i++ {
+		h.Record(time.Duration(i))
+	}
+}
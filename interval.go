@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+)
+
+// IntervalCounter accumulates one worker's operations between interval reports.
+type IntervalCounter struct {
+	sync.Mutex
+	File      string
+	ID        int
+	Op        string
+	Path      string
+	bytes     int64
+	histogram *Histogram
+	ops       int64
+	spare     *Histogram
+}
+
+// IntervalSample summarizes the operations of a worker, a path, or every worker during one interval.
+// Path samples have no file and an ID of -1, and total samples also have no path.
+type IntervalSample struct {
+	Bytes    int64   `json:"bytes"`
+	Duration float64 `json:"duration_s"`
+	File     string  `json:"file,omitempty"`
+	ID       int     `json:"worker_id"`
+	IOPS     float64 `json:"iops"`
+	Max      int64   `json:"max_us"`
+	MiBps    float64 `json:"mib_per_sec"`
+	Op       string  `json:"op"`
+	Ops      int64   `json:"ops"`
+	P50      int64   `json:"p50_us"`
+	P95      int64   `json:"p95_us"`
+	P99      int64   `json:"p99_us"`
+	Path     string  `json:"path,omitempty"`
+	Time     float64 `json:"time_s"` // Seconds since the start of the run at the end of the interval
+}
+
+// IntervalReporter periodically prints and records the throughput, IOPS, and latency of every worker,
+// every path, and all workers together, for the time since its previous report.
+type IntervalReporter struct {
+	Interval  time.Duration
+	Output    io.Writer // Where interval reports are printed
+	Samples   []*IntervalSample
+	Start     time.Time
+	counters  []*IntervalCounter
+	done      chan bool
+	last      time.Time
+	precision int
+	stop      chan bool
+}
+
+func NewIntervalReporter(interval time.Duration, precision int) *IntervalReporter {
+	return &IntervalReporter{
+		Interval:  interval,
+		Output:    os.Stdout,
+		done:      make(chan bool),
+		precision: precision,
+		stop:      make(chan bool),
+	}
+}
+
+// NewCounter returns the counter for one worker performing operation op against filePath in ioPath.
+// Counters must be created before the reporter is started.
+func (r *IntervalReporter) NewCounter(op string, ioPath string, filePath string, id int) *IntervalCounter {
+	c := &IntervalCounter{
+		File:      filePath,
+		ID:        id,
+		Op:        op,
+		Path:      ioPath,
+		histogram: NewHistogram(r.precision),
+		spare:     NewHistogram(r.precision),
+	}
+	r.counters = append(r.counters, c)
+	return c
+}
+
+// Record counts an operation of n bytes which took latency.
+func (c *IntervalCounter) Record(n int64, latency time.Duration) {
+	c.Lock()
+	c.bytes += n
+	c.ops++
+	c.histogram.Record(latency)
+	c.Unlock()
+}
+
+// swap returns the bytes, operations, and latencies recorded since the previous swap, and starts a new
+// interval. The returned histogram is valid until the next call to swap.
+func (c *IntervalCounter) swap() (int64, int64, *Histogram) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.spare.Reset()
+	h := c.histogram
+	c.histogram, c.spare = c.spare, h
+	bytes, ops := c.bytes, c.ops
+	c.bytes, c.ops = 0, 0
+	return bytes, ops, h
+}
+
+// Run reports every interval until Stop is called. start is the start of the run.
+func (r *IntervalReporter) Run(start time.Time) {
+	r.Start = start
+	r.last = start
+
+	t := time.NewTicker(r.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-r.stop:
+			// Report the partial interval since the last tick, so no operation goes uncounted.
+			r.report(time.Now())
+			r.done <- true
+			return
+		case now := <-t.C:
+			r.report(now)
+		}
+	}
+}
+
+// Stop reports the final partial interval and waits for the reporter to return.
+func (r *IntervalReporter) Stop() {
+	r.stop <- true
+	<-r.done
+}
+
+func newIntervalSample(op string, ioPath string, filePath string, id int, bytes int64, ops int64, h *Histogram, elapsed time.Duration, offset time.Duration) *IntervalSample {
+	return &IntervalSample{
+		Bytes:    bytes,
+		Duration: elapsed.Seconds(),
+		File:     filePath,
+		ID:       id,
+		IOPS:     float64(ops) / elapsed.Seconds(),
+		Max:      h.Max().Microseconds(),
+		MiBps:    float64(bytes) / MiB / elapsed.Seconds(),
+		Op:       op,
+		Ops:      ops,
+		P50:      h.Percentile(0.50).Microseconds(),
+		P95:      h.Percentile(0.95).Microseconds(),
+		P99:      h.Percentile(0.99).Microseconds(),
+		Path:     ioPath,
+		Time:     offset.Seconds(),
+	}
+}
+
+// report samples every counter, then prints and records worker, path, and total samples.
+func (r *IntervalReporter) report(now time.Time) {
+	elapsed := now.Sub(r.last)
+	if elapsed <= 0 {
+		return
+	}
+	r.last = now
+	offset := now.Sub(r.Start)
+
+	type aggregate struct {
+		bytes     int64
+		histogram *Histogram
+		ops       int64
+	}
+	paths := make(map[string]map[string]*aggregate)
+	totals := make(map[string]*aggregate)
+	var workers []*IntervalSample
+
+	for _, c := range r.counters {
+		bytes, ops, h := c.swap()
+		workers = append(workers, newIntervalSample(c.Op, c.Path, c.File, c.ID, bytes, ops, h, elapsed, offset))
+
+		if paths[c.Op] == nil {
+			paths[c.Op] = make(map[string]*aggregate)
+			totals[c.Op] = &aggregate{histogram: NewHistogram(r.precision)}
+		}
+		if paths[c.Op][c.Path] == nil {
+			paths[c.Op][c.Path] = &aggregate{histogram: NewHistogram(r.precision)}
+		}
+		for _, a := range []*aggregate{paths[c.Op][c.Path], totals[c.Op]} {
+			a.bytes += bytes
+			a.ops += ops
+			if err := a.histogram.Merge(h); err != nil {
+				log.Printf("ERROR: Unable to merge interval latencies. %s\n", err)
+			}
+		}
+	}
+
+	samples := workers
+	for _, op := range []string{OpRead, OpWrite} {
+		if totals[op] == nil {
+			continue
+		}
+		var ioPaths []string
+		for ioPath := range paths[op] {
+			ioPaths = append(ioPaths, ioPath)
+		}
+		sort.Strings(ioPaths)
+		for _, ioPath := range ioPaths {
+			a := paths[op][ioPath]
+			samples = append(samples, newIntervalSample(op, ioPath, "", -1, a.bytes, a.ops, a.histogram, elapsed, offset))
+		}
+		samples = append(samples, newIntervalSample(op, "", "", -1, totals[op].bytes, totals[op].ops, totals[op].histogram, elapsed, offset))
+	}
+
+	for _, s := range samples {
+		_, _ = fmt.Fprintln(r.Output, s)
+	}
+	r.Samples = append(r.Samples, samples...)
+}
+
+func (s *IntervalSample) Csv() string {
+	return fmt.Sprintf(
+		"%0.3f,%0.3f,\"%s\",\"%s\",\"%s\",%d,%d,%d,%0.2f,%0.2f,%d,%d,%d,%d",
+		s.Time, s.Duration, s.Op, s.Path, s.File, s.ID, s.Bytes, s.Ops, s.MiBps, s.IOPS, s.P50, s.P95, s.P99, s.Max,
+	)
+}
+
+func (s *IntervalSample) String() string {
+	var label string
+
+	switch {
+	case s.File != "":
+		label = fmt.Sprintf("[%d] %s", s.ID, s.File)
+	case s.Path != "":
+		label = s.Path
+	default:
+		label = "Total"
+	}
+	return fmt.Sprintf(
+		"[%0.0fs] %s %s: %0.2f MiB/sec, %0.0f IOPS, P50: %d us, P95: %d us, P99: %d us, Max: %d us",
+		s.Time, s.Op, label, s.MiBps, s.IOPS, s.P50, s.P95, s.P99, s.Max,
+	)
+}
+
+// Write saves every recorded sample to intervals.csv and intervals.json in dir.
+func (r *IntervalReporter) Write(dir string) error {
+	csvFile, err := os.OpenFile(path.Join(dir, "intervals.csv"), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(csvFile)
+	_, err = w.WriteString("\"time s\",\"duration s\",\"op\",\"path\",\"file\",\"worker id\",\"bytes\",\"ops\",\"MiB/sec\",\"IOPS\",\"p50 us\",\"p95 us\",\"p99 us\",\"max us\"\n")
+	for _, s := range r.Samples {
+		if err != nil {
+			break
+		}
+		_, err = w.WriteString(s.Csv() + "\n")
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		_ = csvFile.Close()
+		return err
+	}
+	if err := csvFile.Close(); err != nil {
+		return err
+	}
+
+	samples := r.Samples
+	if samples == nil {
+		samples = []*IntervalSample{}
+	}
+	data, err := json.MarshalIndent(samples, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path.Join(dir, "intervals.json"), append(data, '\n'), 0644)
+}
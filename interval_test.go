@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIntervalReporter(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Now()
+
+	r := NewIntervalReporter(time.Second, HistogramPrecision)
+	r.Output = io.Discard
+	r.Start, r.last = start, start
+	first := r.NewCounter(OpWrite, "/data", "/data/scriba.0.data", 0)
+	second := r.NewCounter(OpWrite, "/data", "/data/scriba.1.data", 1)
+	for i := 0; i < 100; i++ {
+		first.Record(MiB, time.Millisecond)
+		second.Record(MiB, 3*time.Millisecond)
+	}
+
+	r.report(start.Add(2 * time.Second))
+	if len(r.Samples) != 4 {
+		t.Fatalf("Expected 2 worker, 1 path, and 1 total sample, found %d.\n", len(r.Samples))
+	}
+	total := r.Samples[3]
+	if total.Path != "" || total.Ops != 200 || total.MiBps != 100 || total.IOPS != 100 {
+		t.Errorf("Unexpected total sample %+v\n", total)
+	}
+	if total.P50 != 1000 || total.Max != 3000 {
+		t.Errorf("Unexpected total latencies %+v\n", total)
+	}
+	if r.Samples[2].Path != "/data" || r.Samples[2].Bytes != 200*MiB {
+		t.Errorf("Unexpected path sample %+v\n", r.Samples[2])
+	}
+
+	// Every interval starts empty.
+	r.report(start.Add(3 * time.Second))
+	if s := r.Samples[7]; s.Ops != 0 || s.Duration != 1 || s.Time != 3 {
+		t.Errorf("Unexpected second interval %+v\n", s)
+	}
+
+	if err := r.Write(dir); err != nil {
+		t.Fatalf("Unable to write interval samples. %s\n", err)
+	}
+	data, err := os.ReadFile(path.Join(dir, "intervals.csv"))
+	if err != nil {
+		t.Fatalf("Unable to read interval CSV. %s\n", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 9 || lines[1] != `2.000,2.000,"write","/data","/data/scriba.0.data",0,104857600,100,50.00,50.00,1000,1000,1000,1000` {
+		t.Errorf("Unexpected interval CSV %q\n", lines)
+	}
+
+	var samples []*IntervalSample
+	if data, err = os.ReadFile(path.Join(dir, "intervals.json")); err != nil {
+		t.Fatalf("Unable to read interval JSON. %s\n", err)
+	}
+	if err := json.Unmarshal(data, &samples); err != nil || len(samples) != 8 {
+		t.Errorf("Unexpected interval JSON. %v\n", err)
+	}
+}
@@ -20,33 +20,49 @@ const (
 type ReaderConfig struct {
 	BlockSize       int64
 	BytePattern     int
+	Consistency     *ConsistencyTable
 	Direct          bool
 	FileSize        int64
+	Histogram       *Histogram
 	ID              int
+	Interval        *IntervalCounter
+	Outliers        *LatencyOutliers
 	RandomMap       *[]int64
+	Recorder        *LatencyRecorder
 	Results         *IOStats
 	ReadLimit       int64
 	ReadTime        time.Duration
 	ReaderPath      string
 	ReaderType      uint8
+	Seed            int64
 	StartOffset     int64
 	ThroughputBytes int64
 	ThroughputTime  time.Duration
+	Usage           *ThreadUsage // CPU time and context switches of the reader's thread, when available
+	Violations      ConsistencyResult
 }
 
 type WriterConfig struct {
 	BatchSize       int64
 	BlockSize       int64
 	BufferSize      int
-	BytePattern     int
+	Consistency     *ConsistencyTable
+	Data            DataConfig
 	Direct          bool
 	FileSize        int64
+	Histogram       *Histogram
 	ID              int
+	Interval        *IntervalCounter
+	Ledger          *Ledger
+	Outliers        *LatencyOutliers
 	RandomMap       *[]int64
+	Recorder        *LatencyRecorder
 	Results         *IOStats
+	Seed            int64
 	StartOffset     int64
 	ThroughputBytes int64
 	ThroughputTime  time.Duration
+	Usage           *ThreadUsage // CPU time and context switches of the writer's thread, when available
 	WriteLimit      int64
 	WriteTime       time.Duration
 	WriterPath      string
@@ -83,19 +99,31 @@ func dropPageCache() {
 func reader(config *ReaderConfig, wg *sync.WaitGroup) {
 	var (
 		bytesToRead  int64
-		latencies    []time.Duration
+		expected     uint64
+		lastPos      int64
+		racing       bool
 		mapIndex     int
+		records      *LatencyBuffer
 		seek         bool
 		seekPosition int64
 	)
 
 	buf := make([]byte, config.BlockSize)
 	if len(*config.RandomMap) > 0 {
-		mapIndex = rand.Intn(len(*config.RandomMap))
+		mapIndex = rand.New(rand.NewSource(config.Seed)).Intn(len(*config.RandomMap))
 	}
 
 	defer wg.Done()
 
+	// Keep the worker on one thread, so the thread's resource usage is the worker's own.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	if config.Recorder != nil {
+		records = config.Recorder.NewBuffer(OpRead, config.ReaderPath, config.ID)
+		defer records.Flush()
+	}
+
 	workFile, err := os.OpenFile(config.ReaderPath, readerFlags(config.Direct), 0666)
 	if err != nil {
 		log.Printf("[Reader %d] Error opening file %s: %s\n", config.ID, config.ReaderPath, err)
@@ -114,12 +142,14 @@ func reader(config *ReaderConfig, wg *sync.WaitGroup) {
 		if Debug {
 			log.Printf("[Reader %d] New offset %s@%d", config.ID, config.ReaderPath, off)
 		}
+		lastPos = off
 	}
 
 	if Debug {
 		log.Printf("[Reader %d] Starting reader\n", config.ID)
 	}
 
+	startUsage := workerUsage()
 	startTime := time.Now()
 	for {
 		seek = false
@@ -157,11 +187,19 @@ func reader(config *ReaderConfig, wg *sync.WaitGroup) {
 				if mapIndex > len(*config.RandomMap)-1 {
 					mapIndex = 0
 				}
-				seekPosition = (*config.RandomMap)[mapIndex]
-				//seekPosition = rand.Int63n(config.FileSize - bytesToRead)
+				// The random map holds block numbers, not byte offsets.
+				seekPosition = (*config.RandomMap)[mapIndex] * config.BlockSize
 			}
 		}
 
+		if seek {
+			lastPos = seekPosition
+		}
+		if config.Consistency != nil {
+			// Only writes completed before the read starts are expected to be visible.
+			expected, racing = config.Consistency.Completed(config.ReaderPath, lastPos)
+		}
+
 		// Calculate latency only after the new position is determined.
 		latencyStart := time.Now()
 
@@ -174,6 +212,11 @@ func reader(config *ReaderConfig, wg *sync.WaitGroup) {
 
 		n, readErr := workFile.Read(buf[:bytesToRead])
 		config.ThroughputBytes += int64(n)
+		if config.Consistency != nil && int64(n) == config.BlockSize {
+			config.Violations.Check(config.ID, config.ReaderPath, lastPos, expected, racing, buf[:n])
+		}
+		readPos := lastPos
+		lastPos += int64(n)
 		if readErr != nil {
 			if readErr == io.EOF {
 				// We might read a partial buffer here, but detecting EOF and
@@ -185,6 +228,7 @@ func reader(config *ReaderConfig, wg *sync.WaitGroup) {
 				if _, err := workFile.Seek(0, 0); err != nil {
 					log.Printf("[Reader %d]: ERROR Unable to seek to beginning of %s. %s\n", config.ID, config.ReaderPath, readErr)
 				}
+				lastPos = 0
 				continue
 			}
 			log.Printf("[Reader %d] ERROR: Unable to read from %s. %v\n", config.ID, workFile.Name(), readErr)
@@ -192,15 +236,28 @@ func reader(config *ReaderConfig, wg *sync.WaitGroup) {
 		}
 
 		latencyStop := time.Now().Sub(latencyStart)
-		if config.Results != nil {
-			latencies = append(latencies, latencyStop)
+		if config.Histogram != nil {
+			config.Histogram.Record(latencyStop)
+		}
+		if config.Outliers != nil {
+			config.Outliers.Record(latencyStart, latencyStop)
+		}
+		if records != nil {
+			records.Add(latencyStart, readPos, int64(n), latencyStop)
+		}
+		if config.Interval != nil {
+			config.Interval.Record(int64(n), latencyStop)
 		}
 	}
-	config.ThroughputTime = time.Now().Sub(startTime)
+	stopTime := time.Now()
+	config.ThroughputTime = stopTime.Sub(startTime)
+	if stopUsage := workerUsage(); startUsage != nil && stopUsage != nil {
+		config.Usage = stopUsage.Sub(startUsage)
+	}
 
 	if config.Results != nil {
 		config.Results.Lock()
-		config.Results.ReadThroughput[config.ReaderPath] = append(config.Results.ReadThroughput[config.ReaderPath], &Throughput{ID: config.ID, Latencies: latencies})
+		config.Results.ReadThroughput[config.ReaderPath] = append(config.Results.ReadThroughput[config.ReaderPath], newThroughput(config.ID, config.ThroughputBytes, startTime, stopTime, config.Histogram, config.Usage))
 		config.Results.Unlock()
 	}
 
@@ -220,9 +277,10 @@ func writer(config *WriterConfig, wg *sync.WaitGroup) {
 	var (
 		bytesNeeded  int64
 		data         []byte
+		generation   uint64
 		lastPos      int64
-		latencies    []time.Duration
 		mapIndex     int
+		records      *LatencyBuffer
 		seek         bool
 		seekPosition int64
 	)
@@ -230,15 +288,24 @@ func writer(config *WriterConfig, wg *sync.WaitGroup) {
 	readerBufSize := config.BufferSize
 	data = make([]byte, config.BlockSize)
 	if len(*config.RandomMap) > 0 {
-		mapIndex = rand.Intn(len(*config.RandomMap))
+		mapIndex = rand.New(rand.NewSource(config.Seed)).Intn(len(*config.RandomMap))
 	}
 
 	defer wg.Done()
 
+	// Keep the worker on one thread, so the thread's resource usage is the worker's own.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	if config.Recorder != nil {
+		records = config.Recorder.NewBuffer(OpWrite, config.WriterPath, config.ID)
+		defer records.Flush()
+	}
+
 	if Debug {
 		log.Printf("[Writer %d] Generating random data buffer\n", config.ID)
 	}
-	dr := NewDataReader(readerBufSize, config.BytePattern)
+	dr := NewDataReaderFromConfig(readerBufSize, config.Data)
 	if Debug {
 		log.Printf("[Writer %d] Generated %d random bytes", config.ID, readerBufSize)
 	}
@@ -267,6 +334,7 @@ func writer(config *WriterConfig, wg *sync.WaitGroup) {
 	if Debug {
 		log.Printf("[Writer %d] Starting writer\n", config.ID)
 	}
+	startUsage := workerUsage()
 	startTime := time.Now()
 	for {
 		seek = false
@@ -308,12 +376,16 @@ func writer(config *WriterConfig, wg *sync.WaitGroup) {
 				if mapIndex > len(*config.RandomMap)-1 {
 					mapIndex = 0
 				}
-				seekPosition = (*config.RandomMap)[mapIndex]
-				//seekPosition = rand.Int63n(config.FileSize - config.BlockSize)
+				// The random map holds block numbers, not byte offsets.
+				seekPosition = (*config.RandomMap)[mapIndex] * config.BlockSize
 			}
 		}
 
-		if lastPos+bytesNeeded > config.FileSize {
+		nextPos := lastPos
+		if seek {
+			nextPos = seekPosition
+		}
+		if nextPos+bytesNeeded > config.FileSize {
 			if Debug {
 				log.Printf("[Writer %d] %s EOF, seeking to 0", config.ID, config.WriterPath)
 			}
@@ -321,6 +393,30 @@ func writer(config *WriterConfig, wg *sync.WaitGroup) {
 			seek = true
 		}
 
+		writePos := lastPos
+		if seek {
+			writePos = seekPosition
+		}
+		if (config.Ledger != nil || config.Consistency != nil) && bytesNeeded >= BlockHeaderSize {
+			// Mark the write in progress before taking its generation, so a write which completes
+			// before this one begins always holds an older generation.
+			if config.Consistency != nil {
+				config.Consistency.Begin(config.WriterPath, writePos)
+			}
+			if config.Ledger != nil {
+				generation = config.Ledger.NextGeneration()
+			} else {
+				generation = config.Consistency.NextGeneration()
+			}
+			stampBlock(data[:bytesNeeded], writePos, generation)
+		}
+		if config.Ledger != nil && generation != 0 {
+			if err := config.Ledger.Intent(config.WriterPath, writePos, generation); err != nil {
+				log.Printf("[Writer %d] ERROR: Unable to record write intent. %s\n", config.ID, err)
+				return
+			}
+		}
+
 		// Don't count the position calculation in the latency math
 		latencyStart := time.Now()
 		if seek {
@@ -338,24 +434,51 @@ func writer(config *WriterConfig, wg *sync.WaitGroup) {
 			return
 		}
 
-		if config.BatchSize > 0 && config.ThroughputBytes%config.BatchSize == 0 {
+		if config.Ledger != nil && generation != 0 {
+			// Every write must be durable before it is acknowledged in the ledger.
+			if syncError := workFile.Sync(); syncError != nil {
+				log.Printf("[Writer %d] ERROR: Unable to sync %s. %s\n", config.ID, config.WriterPath, syncError)
+				return
+			}
+			if err := config.Ledger.Ack(config.WriterPath, writePos, generation); err != nil {
+				log.Printf("[Writer %d] ERROR: Unable to record write acknowledgement. %s\n", config.ID, err)
+				return
+			}
+		} else if config.BatchSize > 0 && config.ThroughputBytes%config.BatchSize == 0 {
 			_ = workFile.Sync()
 		}
+		if config.Consistency != nil && generation != 0 {
+			config.Consistency.Complete(config.WriterPath, writePos, generation)
+		}
+		generation = 0
 
 		latencyStop := time.Now().Sub(latencyStart)
-		if config.Results != nil {
-			latencies = append(latencies, latencyStop)
+		if config.Histogram != nil {
+			config.Histogram.Record(latencyStop)
+		}
+		if config.Outliers != nil {
+			config.Outliers.Record(latencyStart, latencyStop)
+		}
+		if records != nil {
+			records.Add(latencyStart, writePos, int64(n), latencyStop)
+		}
+		if config.Interval != nil {
+			config.Interval.Record(int64(n), latencyStop)
 		}
 		config.ThroughputBytes += int64(n)
 		lastPos += int64(n)
 	}
 
 	_ = workFile.Sync()
-	config.ThroughputTime = time.Now().Sub(startTime)
+	stopTime := time.Now()
+	config.ThroughputTime = stopTime.Sub(startTime)
+	if stopUsage := workerUsage(); startUsage != nil && stopUsage != nil {
+		config.Usage = stopUsage.Sub(startUsage)
+	}
 
 	if config.Results != nil {
 		config.Results.Lock()
-		config.Results.WriteThroughput[config.WriterPath] = append(config.Results.WriteThroughput[config.WriterPath], &Throughput{ID: config.ID, Latencies: latencies})
+		config.Results.WriteThroughput[config.WriterPath] = append(config.Results.WriteThroughput[config.WriterPath], newThroughput(config.ID, config.ThroughputBytes, startTime, stopTime, config.Histogram, config.Usage))
 		config.Results.Unlock()
 	}
 
@@ -371,7 +494,7 @@ func writer(config *WriterConfig, wg *sync.WaitGroup) {
 	}
 }
 
-func prefill(filePath string, fileSize int64, pattern int, wg *sync.WaitGroup) {
+func prefill(filePath string, fileSize int64, dataConfig DataConfig, wg *sync.WaitGroup) {
 	var (
 		bytesNeeded int64
 		data        []byte
@@ -382,7 +505,7 @@ func prefill(filePath string, fileSize int64, pattern int, wg *sync.WaitGroup) {
 	defer wg.Done()
 
 	data = make([]byte, readerBufSize)
-	dr := NewDataReader(readerBufSize, pattern)
+	dr := NewDataReaderFromConfig(readerBufSize, dataConfig)
 
 	//writerFlags(config.Direct)
 	//workFile, err := os.OpenFile(filePath, os.O_WRONLY, 0644)
@@ -1,7 +1,11 @@
 package main
 
 import (
+	"bytes"
 	"math/rand"
+	"os"
+	"path"
+	"sync"
 	"testing"
 	"time"
 )
@@ -39,3 +43,46 @@ func BenchmarkZipf(b *testing.B) {
 	testDuration := time.Now().Sub(t1)
 	b.Logf("Zipf Uint64 %0.2f/sec, %0.2f sec.", 1000000.0/testDuration.Seconds(), testDuration.Seconds())
 }
+
+func TestWriterRandomMap(t *testing.T) {
+	const (
+		blockSize = 4096
+		blocks    = 8
+	)
+	filePath := path.Join(t.TempDir(), "random")
+
+	// The random map holds block numbers. Writing each block once must cover the whole file, whichever
+	// block the seed starts at, including the blocks written after the file's last block.
+	randomMap := []int64{5, 7, 2, 0, 6, 3, 1, 4}
+	for seed := int64(1); seed <= blocks; seed++ {
+		if err := os.WriteFile(filePath, make([]byte, blocks*blockSize), 0644); err != nil {
+			t.Fatalf("Unable to create %s. %s\n", filePath, err)
+		}
+		wg := &sync.WaitGroup{}
+		wg.Add(1)
+		writer(&WriterConfig{
+			BlockSize:  blockSize,
+			BufferSize: blockSize,
+			Data:       DataConfig{Pattern: PatternFF},
+			FileSize:   blocks * blockSize,
+			RandomMap:  &randomMap,
+			Seed:       seed,
+			WriteLimit: blocks * blockSize,
+			WriterPath: filePath,
+			WriterType: Random,
+		}, wg)
+
+		data, err := os.ReadFile(filePath)
+		if err != nil {
+			t.Fatalf("Unable to read %s. %s\n", filePath, err)
+		}
+		if int64(len(data)) != blocks*blockSize {
+			t.Fatalf("Seed %d: the writer extended %s to %d bytes\n", seed, filePath, len(data))
+		}
+		for block := 0; block < blocks; block++ {
+			if !bytes.Equal(data[block*blockSize:(block+1)*blockSize], bytes.Repeat([]byte{0xff}, blockSize)) {
+				t.Errorf("Seed %d: block %d was not written\n", seed, block)
+			}
+		}
+	}
+}
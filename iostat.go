@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+const SectorSize = 512 // sysfs block statistics count 512 byte sectors regardless of the device's block size
+
+// DiskMetrics are iostat style rates derived from the change between two sysfsDiskStats samples.
+type DiskMetrics struct {
+	AvgRequestSize float64       `json:"areq_sz_kib"`  // Average size of completed requests in KiB
+	Await          float64       `json:"await_ms"`     // Average time reads and writes took to complete, including queueing
+	DiscardMBps    float64       `json:"discard_mb_s"` // MiB discarded per second
+	DiscardsPerSec float64       `json:"discards_s"`   // Completed discard requests per second
+	Offset         time.Duration `json:"-"`            // Time since the start of the run of the later sample
+	Duration       float64       `json:"duration_s"`   // Seconds between the two samples
+	QueueSize      float64       `json:"aqu_sz"`       // Average number of requests queued or in flight
+	ReadAwait      float64       `json:"r_await_ms"`   // Average time reads took to complete
+	ReadMBps       float64       `json:"read_mb_s"`    // MiB read per second
+	ReadsPerSec    float64       `json:"reads_s"`      // Completed reads per second
+	Time           time.Time     `json:"-"`            // The time of the later sample
+	Utilization    float64       `json:"util_pct"`     // Percentage of time the device had requests in flight
+	WriteAwait     float64       `json:"w_await_ms"`   // Average time writes took to complete
+	WriteMBps      float64       `json:"write_mb_s"`   // MiB written per second
+	WritesPerSec   float64       `json:"writes_s"`     // Completed writes per second
+}
+
+// DiskMetricsSummary holds a device's metrics from its first to its last sample, and the peak of each
+// metric over any single sampling interval. Both are nil when the device has fewer than two samples.
+type DiskMetricsSummary struct {
+	Average *DiskMetrics
+	Device  string
+	Peak    *DiskMetrics
+}
+
+// counterDelta returns the increase of a cumulative counter, treating a counter which went backwards,
+// such as after a device reset, as unchanged.
+func counterDelta(previous int, current int) float64 {
+	if current < previous {
+		return 0
+	}
+	return float64(current - previous)
+}
+
+// deriveDiskMetrics returns the rates between samples previous and current, or nil when no time passed.
+func deriveDiskMetrics(previous *sysfsDiskStats, current *sysfsDiskStats) *DiskMetrics {
+	elapsed := current.Time.Sub(previous.Time)
+	if elapsed <= 0 {
+		return nil
+	}
+	seconds := elapsed.Seconds()
+	milliseconds := seconds * 1000
+
+	reads := counterDelta(previous.ReadIO, current.ReadIO)
+	writes := counterDelta(previous.WriteIO, current.WriteIO)
+	discards := counterDelta(previous.DiscardIO, current.DiscardIO)
+	readSectors := counterDelta(previous.ReadSectors, current.ReadSectors)
+	writeSectors := counterDelta(previous.WriteSectors, current.WriteSectors)
+	discardSectors := counterDelta(previous.DiscardSectors, current.DiscardSectors)
+	readTime := counterDelta(previous.ReadTime, current.ReadTime)
+	writeTime := counterDelta(previous.WriteTime, current.WriteTime)
+
+	m := &DiskMetrics{
+		DiscardMBps:    discardSectors * SectorSize / MiB / seconds,
+		DiscardsPerSec: discards / seconds,
+		Duration:       seconds,
+		Offset:         current.Offset,
+		QueueSize:      counterDelta(previous.TimeInQueue, current.TimeInQueue) / milliseconds,
+		ReadMBps:       readSectors * SectorSize / MiB / seconds,
+		ReadsPerSec:    reads / seconds,
+		Time:           current.Time,
+		Utilization:    100 * counterDelta(previous.IOTime, current.IOTime) / milliseconds,
+		WriteMBps:      writeSectors * SectorSize / MiB / seconds,
+		WritesPerSec:   writes / seconds,
+	}
+	if m.Utilization > 100 {
+		// IO time is only updated as requests complete, so a sample can account slightly more than elapsed.
+		m.Utilization = 100
+	}
+	if reads > 0 {
+		m.ReadAwait = readTime / reads
+	}
+	if writes > 0 {
+		m.WriteAwait = writeTime / writes
+	}
+	if reads+writes > 0 {
+		m.Await = (readTime + writeTime) / (reads + writes)
+		m.AvgRequestSize = (readSectors + writeSectors) * SectorSize / KiB / (reads + writes)
+	}
+	return m
+}
+
+// Metrics returns the metrics of every interval between consecutive samples.
+func (s *diskStats) Metrics() []*DiskMetrics {
+	var metrics []*DiskMetrics
+
+	for i := 1; i < len(s.Stats); i++ {
+		if m := deriveDiskMetrics(s.Stats[i-1], s.Stats[i]); m != nil {
+			metrics = append(metrics, m)
+		}
+	}
+	return metrics
+}
+
+// Summary returns the device's metrics between its first and last samples, and the peak of each metric
+// over any single interval.
+func (s *diskStats) Summary() *DiskMetricsSummary {
+	summary := &DiskMetricsSummary{Device: s.Device}
+	if len(s.Stats) < 2 {
+		return summary
+	}
+	summary.Average = deriveDiskMetrics(s.Stats[0], s.Stats[len(s.Stats)-1])
+	if summary.Average == nil {
+		return summary
+	}
+	summary.Peak = &DiskMetrics{Duration: summary.Average.Duration, Offset: summary.Average.Offset, Time: summary.Average.Time}
+
+	for _, m := range s.Metrics() {
+		p := summary.Peak
+		for _, pair := range [][2]*float64{
+			{&p.AvgRequestSize, &m.AvgRequestSize},
+			{&p.Await, &m.Await},
+			{&p.DiscardMBps, &m.DiscardMBps},
+			{&p.DiscardsPerSec, &m.DiscardsPerSec},
+			{&p.QueueSize, &m.QueueSize},
+			{&p.ReadAwait, &m.ReadAwait},
+			{&p.ReadMBps, &m.ReadMBps},
+			{&p.ReadsPerSec, &m.ReadsPerSec},
+			{&p.Utilization, &m.Utilization},
+			{&p.WriteAwait, &m.WriteAwait},
+			{&p.WriteMBps, &m.WriteMBps},
+			{&p.WritesPerSec, &m.WritesPerSec},
+		} {
+			if *pair[1] > *pair[0] {
+				*pair[0] = *pair[1]
+			}
+		}
+	}
+	return summary
+}
+
+func (m *DiskMetrics) Csv() string {
+	return fmt.Sprintf(
+		"%d,%0.3f,%0.2f,%0.2f,%0.2f,%0.2f,%0.2f,%0.2f,%0.2f,%0.2f,%0.2f,%0.2f,%0.2f,%0.2f",
+		m.Offset.Milliseconds(), m.Duration, m.ReadsPerSec, m.WritesPerSec, m.ReadMBps, m.WriteMBps, m.AvgRequestSize,
+		m.ReadAwait, m.WriteAwait, m.Await, m.QueueSize, m.Utilization, m.DiscardsPerSec, m.DiscardMBps,
+	)
+}
+
+func (m *DiskMetrics) String() string {
+	return fmt.Sprintf(
+		"r/s: %0.1f, w/s: %0.1f, rMB/s: %0.2f, wMB/s: %0.2f, areq-sz: %0.1f KiB, r_await: %0.2f ms, w_await: %0.2f ms, aqu-sz: %0.2f, %%util: %0.1f, d/s: %0.1f, dMB/s: %0.2f",
+		m.ReadsPerSec, m.WritesPerSec, m.ReadMBps, m.WriteMBps, m.AvgRequestSize,
+		m.ReadAwait, m.WriteAwait, m.QueueSize, m.Utilization, m.DiscardsPerSec, m.DiscardMBps,
+	)
+}
+
+func (s *DiskMetricsSummary) String() string {
+	if s.Average == nil {
+		return fmt.Sprintf("%s: Not enough samples\n", s.Device)
+	}
+	return fmt.Sprintf("%s:\n  Average: %s\n  Peak:    %s\n", s.Device, s.Average, s.Peak)
+}
+
+// WriteMetrics saves the derived metrics of every device to diskmetrics.DEVICE.csv in dir.
+func (s *SysStatsCollection) WriteMetrics(dir string) error {
+	for _, value := range s.Disk {
+		var output strings.Builder
+
+		output.WriteString("\"time ms\",\"duration s\",\"r/s\",\"w/s\",\"rMB/s\",\"wMB/s\",\"areq-sz KiB\",\"r_await ms\",\"w_await ms\",\"await ms\",\"aqu-sz\",\"%util\",\"d/s\",\"dMB/s\"\n")
+		for _, m := range value.Metrics() {
+			output.WriteString(m.Csv() + "\n")
+		}
+		if err := os.WriteFile(path.Join(dir, fmt.Sprintf("diskmetrics.%s.csv", value.Device)), []byte(output.String()), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDeriveDiskMetrics(t *testing.T) {
+	start := time.Now()
+	previous := &sysfsDiskStats{IOTime: 1000, ReadIO: 100, ReadSectors: 800, ReadTime: 50, Time: start, TimeInQueue: 2000, WriteIO: 10, WriteSectors: 80}
+	current := &sysfsDiskStats{
+		DiscardIO:      4,
+		DiscardSectors: 4096,
+		IOTime:         1500,
+		ReadIO:         300,
+		ReadSectors:    2848,
+		ReadTime:       250,
+		Time:           start.Add(2 * time.Second),
+		TimeInQueue:    6000,
+		WriteIO:        10,
+		WriteSectors:   80,
+	}
+
+	m := deriveDiskMetrics(previous, current)
+	if m.ReadsPerSec != 100 || m.WritesPerSec != 0 || m.ReadMBps != 0.5 || m.AvgRequestSize != 5.12 {
+		t.Errorf("Unexpected rates %+v\n", m)
+	}
+	if m.ReadAwait != 1 || m.Await != 1 || m.QueueSize != 2 || m.Utilization != 25 {
+		t.Errorf("Unexpected queue metrics %+v\n", m)
+	}
+	if m.DiscardsPerSec != 2 || m.DiscardMBps != 1 {
+		t.Errorf("Unexpected discard metrics %+v\n", m)
+	}
+
+	// A counter which went backwards is treated as unchanged, rather than as a huge negative rate.
+	current.ReadIO = 50
+	if m := deriveDiskMetrics(previous, current); m.ReadsPerSec != 0 {
+		t.Errorf("Unexpected reads after a counter reset %0.2f\n", m.ReadsPerSec)
+	}
+	if m := deriveDiskMetrics(current, current); m != nil {
+		t.Errorf("Samples at the same time should have no metrics %+v\n", m)
+	}
+}
+
+func TestDiskMetricsSummary(t *testing.T) {
+	start := time.Now()
+	d := &diskStats{Device: "sda", Stats: []*sysfsDiskStats{
+		{Time: start},
+		{Time: start.Add(time.Second), WriteIO: 300, WriteSectors: 2400},
+		{Time: start.Add(2 * time.Second), WriteIO: 400, WriteSectors: 3200},
+	}}
+
+	summary := d.Summary()
+	if summary.Average.WritesPerSec != 200 || summary.Peak.WritesPerSec != 300 {
+		t.Errorf("Unexpected summary average %0.2f, peak %0.2f\n", summary.Average.WritesPerSec, summary.Peak.WritesPerSec)
+	}
+	if len(d.Metrics()) != 2 {
+		t.Errorf("Expected 2 intervals, found %d\n", len(d.Metrics()))
+	}
+	if summary := (&diskStats{Device: "sdb"}).Summary(); summary.Average != nil || summary.Peak != nil {
+		t.Errorf("A device without samples should have no summary %+v\n", summary)
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bufio"
+	"log"
+	"os"
+	"path"
+	"strconv"
+	"time"
+)
+
+const (
+	LatencyBatchSize  = 4096 // Records a worker buffers before handing them to the recorder
+	LatencyQueueDepth = 64   // Batches queued for the recorder before workers wait for it
+)
+
+const (
+	OpRead  = "read"
+	OpWrite = "write"
+)
+
+type latencyRecord struct {
+	Latency time.Duration
+	Offset  int64
+	Size    int64
+	Time    time.Duration // Time since the start of the run that the operation started
+}
+
+type latencyBatch struct {
+	ID      int
+	Op      string
+	Path    string
+	Records []latencyRecord
+}
+
+// LatencyRecorder streams raw per-operation latency records to CSV files from a background routine,
+// so memory use stays bounded and records written before a crash or interruption are kept.
+type LatencyRecorder struct {
+	Start   time.Time
+	batches chan *latencyBatch
+	done    chan error
+	files   map[string]*os.File
+	writers map[string]*bufio.Writer
+}
+
+// LatencyBuffer collects one worker's records, and hands them to the recorder in batches.
+type LatencyBuffer struct {
+	batch    *latencyBatch
+	recorder *LatencyRecorder
+}
+
+// NewLatencyRecorder creates readers.csv and writers.csv in dir and starts the background routine.
+func NewLatencyRecorder(dir string, start time.Time) (*LatencyRecorder, error) {
+	r := &LatencyRecorder{
+		Start:   start,
+		batches: make(chan *latencyBatch, LatencyQueueDepth),
+		done:    make(chan error),
+		files:   make(map[string]*os.File),
+		writers: make(map[string]*bufio.Writer),
+	}
+
+	for op, name := range map[string]string{OpRead: "readers.csv", OpWrite: "writers.csv"} {
+		statsFile, err := os.OpenFile(path.Join(dir, name), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+		if err != nil {
+			r.closeFiles()
+			return nil, err
+		}
+		r.files[op] = statsFile
+		r.writers[op] = bufio.NewWriterSize(statsFile, 1*MiB)
+		if _, err := r.writers[op].WriteString("\"time us\",\"path\",\"worker id\",\"op\",\"offset\",\"size\",\"latency us\"\n"); err != nil {
+			r.closeFiles()
+			return nil, err
+		}
+		if err := r.writers[op].Flush(); err != nil {
+			r.closeFiles()
+			return nil, err
+		}
+	}
+
+	go r.run()
+	return r, nil
+}
+
+func (r *LatencyRecorder) closeFiles() {
+	for _, f := range r.files {
+		_ = f.Close()
+	}
+}
+
+func (r *LatencyRecorder) run() {
+	var line []byte
+	var writeErr error
+
+	for batch := range r.batches {
+		if writeErr != nil {
+			// Keep draining batches, so workers are never blocked by a failed recorder.
+			continue
+		}
+
+		w := r.writers[batch.Op]
+		quotedPath := strconv.Quote(batch.Path)
+		for _, record := range batch.Records {
+			line = line[:0]
+			line = strconv.AppendInt(line, record.Time.Microseconds(), 10)
+			line = append(line, ',')
+			line = append(line, quotedPath...)
+			line = append(line, ',')
+			line = strconv.AppendInt(line, int64(batch.ID), 10)
+			line = append(line, ',', '"')
+			line = append(line, batch.Op...)
+			line = append(line, '"', ',')
+			line = strconv.AppendInt(line, record.Offset, 10)
+			line = append(line, ',')
+			line = strconv.AppendInt(line, record.Size, 10)
+			line = append(line, ',')
+			line = strconv.AppendInt(line, record.Latency.Microseconds(), 10)
+			line = append(line, '\n')
+			if _, writeErr = w.Write(line); writeErr != nil {
+				break
+			}
+		}
+		if writeErr == nil {
+			// Every batch reaches the OS as soon as it is written, so it survives a crash of scriba.
+			writeErr = w.Flush()
+		}
+		if writeErr != nil {
+			log.Printf("ERROR: Unable to write latency records. %s\n", writeErr)
+		}
+	}
+
+	for op, f := range r.files {
+		if flushErr := r.writers[op].Flush(); flushErr != nil && writeErr == nil {
+			writeErr = flushErr
+		}
+		_ = f.Sync()
+		if closeErr := f.Close(); closeErr != nil && writeErr == nil {
+			writeErr = closeErr
+		}
+	}
+	r.done <- writeErr
+}
+
+// Close waits for every queued batch to be written, then closes the CSV files.
+// Every worker must have flushed its buffer before Close is called.
+func (r *LatencyRecorder) Close() error {
+	close(r.batches)
+	return <-r.done
+}
+
+// NewBuffer returns a buffer for one worker's records of operation op against filePath.
+func (r *LatencyRecorder) NewBuffer(op string, filePath string, id int) *LatencyBuffer {
+	b := &LatencyBuffer{recorder: r}
+	b.batch = &latencyBatch{ID: id, Op: op, Path: filePath, Records: make([]latencyRecord, 0, LatencyBatchSize)}
+	return b
+}
+
+// Add records an operation which started at start, and hands a full batch to the recorder.
+func (b *LatencyBuffer) Add(start time.Time, offset int64, size int64, latency time.Duration) {
+	b.batch.Records = append(b.batch.Records, latencyRecord{
+		Latency: latency,
+		Offset:  offset,
+		Size:    size,
+		Time:    start.Sub(b.recorder.Start),
+	})
+	if len(b.batch.Records) >= LatencyBatchSize {
+		b.Flush()
+	}
+}
+
+// Flush hands any buffered records to the recorder.
+func (b *LatencyBuffer) Flush() {
+	if len(b.batch.Records) == 0 {
+		return
+	}
+	b.recorder.batches <- b.batch
+	b.batch = &latencyBatch{ID: b.batch.ID, Op: b.batch.Op, Path: b.batch.Path, Records: make([]latencyRecord, 0, LatencyBatchSize)}
+}
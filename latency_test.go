@@ -0,0 +1,43 @@
+package main
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLatencyRecorder(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Now()
+
+	recorder, err := NewLatencyRecorder(dir, start)
+	if err != nil {
+		t.Fatalf("Unable to create latency recorder. %s\n", err)
+	}
+	records := recorder.NewBuffer(OpWrite, "/data/scriba.0.data", 3)
+	for i := 0; i < LatencyBatchSize+10; i++ {
+		records.Add(start.Add(time.Duration(i)*time.Millisecond), int64(i)*4096, 4096, 250*time.Microsecond)
+	}
+	records.Flush()
+	if err := recorder.Close(); err != nil {
+		t.Fatalf("Unable to close latency recorder. %s\n", err)
+	}
+
+	data, err := os.ReadFile(path.Join(dir, "writers.csv"))
+	if err != nil {
+		t.Fatalf("Unable to read writer records. %s\n", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != LatencyBatchSize+11 {
+		t.Errorf("Expected %d lines, found %d.\n", LatencyBatchSize+11, len(lines))
+	}
+	if lines[2] != `1000,"/data/scriba.0.data",3,"write",4096,4096,250` {
+		t.Errorf("Unexpected record %s\n", lines[2])
+	}
+
+	if data, err = os.ReadFile(path.Join(dir, "readers.csv")); err != nil || strings.Count(string(data), "\n") != 1 {
+		t.Errorf("Reader records should only hold a header. %q, %v\n", data, err)
+	}
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	BlockHeaderSize = 32                 // Bytes at the start of each block holding a blockHeader
+	blockMagic      = 0x5343524942414c47 // "SCRIBALG"
+	ledgerVersion   = 1
+)
+
+// blockHeader identifies the write which produced a block, so its content can be checked later.
+type blockHeader struct {
+	Checksum   uint32 // CRC32 of the block following the header
+	Generation uint64
+	Length     uint32
+	Offset     int64
+}
+
+// Ledger records which blocks writers intended to write and which were acknowledged durable.
+// Every record is durable before it is returned, and records of concurrent writers share a Sync.
+type Ledger struct {
+	sync.Mutex
+	BlockSize  int64
+	file       *os.File
+	generation uint64
+	syncDone   *sync.Cond // Signalled when a Sync finishes
+	synced     uint64     // Records known to be durable
+	syncing    bool       // A Sync is in progress
+	w          *bufio.Writer
+	written    uint64 // Records written to the OS
+}
+
+// ledgerWrite tracks one generation written to a block while the ledger is replayed.
+type ledgerWrite struct {
+	ackSeq     int64 // Ledger sequence of the acknowledgement, or zero if it was never acknowledged
+	generation uint64
+	intentSeq  int64
+}
+
+type ledgerBlock struct {
+	last   *ledgerWrite // The most recently acknowledged write
+	writes []*ledgerWrite
+}
+
+// LedgerCheckResult counts the outcome of checking every block recorded in a ledger for one file.
+type LedgerCheckResult struct {
+	Corrupt        int // Acknowledged blocks whose checksum does not match
+	InFlightLanded int // Blocks holding an unacknowledged write, which is allowed
+	InFlightLost   int // Blocks where an unacknowledged write did not land, which is allowed
+	Intact         int
+	Lost           int // Acknowledged blocks which no longer hold any recognizable write
+	Path           string
+	Reverted       int // Acknowledged blocks which hold a write older than the acknowledged one
+	Unknown        int // Blocks holding a generation the ledger has no record of
+	Violations     []string
+}
+
+// stampBlock writes a blockHeader for offset and generation at the start of block.
+func stampBlock(block []byte, offset int64, generation uint64) {
+	binary.LittleEndian.PutUint64(block[0:], blockMagic)
+	binary.LittleEndian.PutUint64(block[8:], uint64(offset))
+	binary.LittleEndian.PutUint64(block[16:], generation)
+	binary.LittleEndian.PutUint32(block[24:], uint32(len(block)))
+	binary.LittleEndian.PutUint32(block[28:], crc32.ChecksumIEEE(block[BlockHeaderSize:]))
+}
+
+// readBlockHeader returns the header at the start of block. The first result is false when the
+// block holds no header, and the second is false when the header's checksum does not match.
+func readBlockHeader(block []byte) (blockHeader, bool, bool) {
+	var header blockHeader
+
+	if len(block) < BlockHeaderSize || binary.LittleEndian.Uint64(block[0:]) != blockMagic {
+		return header, false, false
+	}
+	header.Offset = int64(binary.LittleEndian.Uint64(block[8:]))
+	header.Generation = binary.LittleEndian.Uint64(block[16:])
+	header.Length = binary.LittleEndian.Uint32(block[24:])
+	header.Checksum = binary.LittleEndian.Uint32(block[28:])
+	if int(header.Length) < BlockHeaderSize || int(header.Length) > len(block) {
+		return header, true, false
+	}
+
+	return header, true, crc32.ChecksumIEEE(block[BlockHeaderSize:header.Length]) == header.Checksum
+}
+
+// OpenLedger creates a new ledger at ledgerPath, replacing any existing ledger.
+func OpenLedger(ledgerPath string, blockSize int64) (*Ledger, error) {
+	ledgerFile, err := os.OpenFile(ledgerPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	// Generations continue to increase across runs, so blocks left by an earlier run are never newer.
+	l := &Ledger{BlockSize: blockSize, file: ledgerFile, generation: uint64(time.Now().UnixNano()), w: bufio.NewWriter(ledgerFile)}
+	l.syncDone = sync.NewCond(l)
+	if _, err := fmt.Fprintf(l.w, "scriba-ledger %d %d\n", ledgerVersion, blockSize); err == nil {
+		err = l.w.Flush()
+	}
+	if err == nil {
+		err = ledgerFile.Sync()
+	}
+	if err != nil {
+		_ = ledgerFile.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// record writes a ledger record and waits until it is durable, so an intent is on stable storage before
+// its data write is issued, and an acknowledgement before the writer moves on.
+func (l *Ledger) record(kind string, filePath string, offset int64, generation uint64) error {
+	l.Lock()
+	defer l.Unlock()
+
+	if _, err := fmt.Fprintf(l.w, "%s %q %d %d\n", kind, filePath, offset, generation); err != nil {
+		return err
+	}
+	if err := l.w.Flush(); err != nil {
+		return err
+	}
+	l.written++
+	return l.syncThrough(l.written)
+}
+
+// syncThrough waits until the first records written to the ledger are durable. Records written while
+// a Sync is in progress are made durable together by the next one, so concurrent writers share the cost
+// of syncing the ledger. l must be locked.
+func (l *Ledger) syncThrough(records uint64) error {
+	for l.synced < records {
+		if l.syncing {
+			l.syncDone.Wait()
+			continue
+		}
+
+		l.syncing = true
+		written := l.written
+		l.Unlock()
+		err := l.file.Sync()
+		l.Lock()
+		l.syncing = false
+		l.syncDone.Broadcast()
+		if err != nil {
+			return err
+		}
+		l.synced = written
+	}
+	return nil
+}
+
+// NextGeneration returns a new generation number for a write.
+func (l *Ledger) NextGeneration() uint64 {
+	l.Lock()
+	defer l.Unlock()
+
+	l.generation++
+	return l.generation
+}
+
+// Intent records that a write of generation to offset is about to be issued.
+func (l *Ledger) Intent(filePath string, offset int64, generation uint64) error {
+	return l.record("I", filePath, offset, generation)
+}
+
+// Ack records that the write of generation to offset was acknowledged durable.
+func (l *Ledger) Ack(filePath string, offset int64, generation uint64) error {
+	return l.record("A", filePath, offset, generation)
+}
+
+func (l *Ledger) Close() error {
+	l.Lock()
+	defer l.Unlock()
+
+	if err := l.w.Flush(); err != nil {
+		return err
+	}
+	if err := l.file.Sync(); err != nil {
+		return err
+	}
+	return l.file.Close()
+}
+
+// ack marks generation acknowledged, and forgets writes which completed before it was issued,
+// since they can no longer legitimately be the content of the block.
+func (b *ledgerBlock) ack(generation uint64, sequence int64) {
+	for _, w := range b.writes {
+		if w.generation == generation {
+			w.ackSeq = sequence
+			b.last = w
+		}
+	}
+	if b.last == nil {
+		return
+	}
+
+	writes := b.writes[:0]
+	for _, w := range b.writes {
+		if w == b.last || w.ackSeq == 0 || w.ackSeq > b.last.intentSeq {
+			writes = append(writes, w)
+		}
+	}
+	b.writes = writes
+}
+
+// allowed reports whether a block may hold generation: either the last acknowledged write, or a
+// write which overlapped or followed it and so may have landed after it.
+func (b *ledgerBlock) allowed(generation uint64) (*ledgerWrite, bool) {
+	for _, w := range b.writes {
+		if w.generation == generation {
+			return w, b.last == nil || w == b.last || w.ackSeq == 0 || w.ackSeq > b.last.intentSeq
+		}
+	}
+	return nil, false
+}
+
+// inFlight reports whether a write newer than the last acknowledged one was never acknowledged.
+func (b *ledgerBlock) inFlight() bool {
+	for _, w := range b.writes {
+		if w.ackSeq == 0 && (b.last == nil || w.intentSeq > b.last.intentSeq) {
+			return true
+		}
+	}
+	return false
+}
+
+// readLedger replays a ledger, returning its block size and the writes recorded for each file and offset.
+func readLedger(ledgerPath string) (int64, map[string]map[int64]*ledgerBlock, error) {
+	var blockSize int64
+	var version int
+
+	ledgerFile, err := os.Open(ledgerPath)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer ledgerFile.Close()
+
+	scanner := bufio.NewScanner(ledgerFile)
+	if !scanner.Scan() {
+		return 0, nil, fmt.Errorf("ledger %s is empty", ledgerPath)
+	}
+	if _, err := fmt.Sscanf(scanner.Text(), "scriba-ledger %d %d", &version, &blockSize); err != nil || version != ledgerVersion {
+		return 0, nil, fmt.Errorf("ledger %s has an unsupported header", ledgerPath)
+	}
+
+	files := make(map[string]map[int64]*ledgerBlock)
+	for sequence := int64(1); scanner.Scan(); sequence++ {
+		var filePath string
+		var generation uint64
+		var kind string
+		var offset int64
+
+		if _, err := fmt.Sscanf(scanner.Text(), "%s %q %d %d", &kind, &filePath, &offset, &generation); err != nil {
+			// A crash can leave a partial last record, which is ignored.
+			if Verbose {
+				log.Printf("Skipping malformed ledger record %d. %s\n", sequence, err)
+			}
+			continue
+		}
+		if files[filePath] == nil {
+			files[filePath] = make(map[int64]*ledgerBlock)
+		}
+		block := files[filePath][offset]
+		if block == nil {
+			block = &ledgerBlock{}
+			files[filePath][offset] = block
+		}
+
+		switch kind {
+		case "I":
+			block.writes = append(block.writes, &ledgerWrite{generation: generation, intentSeq: sequence})
+		case "A":
+			block.ack(generation, sequence)
+		}
+	}
+
+	return blockSize, files, scanner.Err()
+}
+
+func (r *LedgerCheckResult) violation(format string, a ...interface{}) {
+	if len(r.Violations) < 100 {
+		r.Violations = append(r.Violations, fmt.Sprintf(format, a...))
+	}
+}
+
+// checkBlock classifies the content of one block against the writes the ledger recorded for it.
+func (r *LedgerCheckResult) checkBlock(offset int64, block *ledgerBlock, data []byte) {
+	header, valid, intact := readBlockHeader(data)
+	if valid && header.Offset != offset {
+		valid = false
+	}
+
+	if !valid {
+		if block.last == nil {
+			r.InFlightLost++
+			return
+		}
+		r.Lost++
+		r.violation("%d: acknowledged generation %d lost", offset, block.last.generation)
+		return
+	}
+
+	w, allowed := block.allowed(header.Generation)
+	switch {
+	case allowed && w == block.last && !intact:
+		r.Corrupt++
+		r.violation("%d: acknowledged generation %d is corrupt", offset, header.Generation)
+	case allowed && w == block.last && block.inFlight():
+		r.InFlightLost++
+	case allowed && w == block.last:
+		r.Intact++
+	case allowed:
+		// Unacknowledged writes may land, torn or whole.
+		r.InFlightLanded++
+	case w != nil || (block.last != nil && header.Generation < block.last.generation):
+		r.Reverted++
+		r.violation("%d: reverted to generation %d from acknowledged generation %d", offset, header.Generation, block.last.generation)
+	default:
+		r.Unknown++
+		r.violation("%d: holds unrecorded generation %d", offset, header.Generation)
+	}
+}
+
+// CheckLedger re-reads every block recorded in the ledger and reports acknowledged writes which were
+// lost or reverted, separately from unacknowledged in-flight writes which are allowed to be lost.
+func CheckLedger(ledgerPath string, direct bool) ([]*LedgerCheckResult, error) {
+	var results []*LedgerCheckResult
+
+	blockSize, files, err := readLedger(ledgerPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for filePath := range files {
+		paths = append(paths, filePath)
+	}
+	sort.Strings(paths)
+
+	data := make([]byte, blockSize)
+	for _, filePath := range paths {
+		result := &LedgerCheckResult{Path: filePath}
+		results = append(results, result)
+
+		workFile, err := os.OpenFile(filePath, readerFlags(direct), 0644)
+		if err != nil {
+			log.Printf("ERROR: Unable to open %s for checking. %s\n", filePath, err)
+			result.violation("unable to open file. %s", err)
+			continue
+		}
+
+		var offsets []int64
+		for offset := range files[filePath] {
+			offsets = append(offsets, offset)
+		}
+		sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+
+		for _, offset := range offsets {
+			n, err := workFile.ReadAt(data, offset)
+			if err != nil && err != io.EOF {
+				result.violation("%d: unable to read block. %s", offset, err)
+				n = 0
+			}
+			result.checkBlock(offset, files[filePath][offset], data[:n])
+		}
+		_ = workFile.Close()
+	}
+
+	return results, nil
+}
+
+func (r *LedgerCheckResult) Failed() bool {
+	return r.Corrupt+r.Lost+r.Reverted+r.Unknown > 0 || len(r.Violations) > 0
+}
+
+func (r *LedgerCheckResult) String() string {
+	var output string
+
+	output += fmt.Sprintf(
+		"%s: %d intact, %d lost, %d reverted, %d corrupt, %d unknown, %d in-flight landed, %d in-flight lost\n",
+		r.Path, r.Intact, r.Lost, r.Reverted, r.Corrupt, r.Unknown, r.InFlightLanded, r.InFlightLost,
+	)
+	if len(r.Violations) > 0 {
+		output += "  " + strings.Join(r.Violations, "\n  ") + "\n"
+	}
+	return output
+}
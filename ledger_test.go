@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestBlockHeader(t *testing.T) {
+	block := make([]byte, 4096)
+	stampBlock(block, 8192, 42)
+
+	header, valid, intact := readBlockHeader(block)
+	if !valid || !intact {
+		t.Fatalf("Stamped block is not valid and intact. %v, %v\n", valid, intact)
+	}
+	if header.Offset != 8192 || header.Generation != 42 || header.Length != 4096 {
+		t.Errorf("Header fields do not match. %+v\n", header)
+	}
+
+	block[100] ^= 0xFF
+	if _, valid, intact = readBlockHeader(block); !valid || intact {
+		t.Errorf("Damaged block was not detected. %v, %v\n", valid, intact)
+	}
+	if _, valid, _ = readBlockHeader(make([]byte, 4096)); valid {
+		t.Error("Zeroed block has a valid header.")
+	}
+}
+
+func TestLedgerGroupCommit(t *testing.T) {
+	ledgerPath := path.Join(t.TempDir(), "ledger")
+	ledger, err := OpenLedger(ledgerPath, 4096)
+	if err != nil {
+		t.Fatalf("Unable to create ledger. %s\n", err)
+	}
+
+	wg := &sync.WaitGroup{}
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for block := 0; block < 32; block++ {
+				offset := int64(block * 4096)
+				generation := ledger.NextGeneration()
+				if err := ledger.Intent(fmt.Sprintf("scriba.%d.data", w), offset, generation); err != nil {
+					t.Errorf("Unable to record intent. %s\n", err)
+				}
+				if err := ledger.Ack(fmt.Sprintf("scriba.%d.data", w), offset, generation); err != nil {
+					t.Errorf("Unable to record acknowledgement. %s\n", err)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	// Every record is synced before it returns, so none can be left waiting for a later Sync.
+	if ledger.synced != 8*32*2 || ledger.written != ledger.synced {
+		t.Errorf("Expected %d synced records, found %d synced of %d written\n", 8*32*2, ledger.synced, ledger.written)
+	}
+	if err := ledger.Close(); err != nil {
+		t.Fatalf("Unable to close ledger. %s\n", err)
+	}
+	data, err := os.ReadFile(ledgerPath)
+	if err != nil {
+		t.Fatalf("Unable to read ledger. %s\n", err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != 1+8*32*2 {
+		t.Errorf("Expected %d ledger lines, found %d\n", 1+8*32*2, lines)
+	}
+}
+
+func TestCheckLedger(t *testing.T) {
+	dir := t.TempDir()
+	dataPath := path.Join(dir, "scriba.0.data")
+	ledgerPath := path.Join(dir, "ledger")
+	data := make([]byte, 4*4096)
+
+	ledger, err := OpenLedger(ledgerPath, 4096)
+	if err != nil {
+		t.Fatalf("Unable to create ledger. %s\n", err)
+	}
+	write := func(block int, ack bool, land bool) uint64 {
+		offset := int64(block * 4096)
+		generation := ledger.NextGeneration()
+		_ = ledger.Intent(dataPath, offset, generation)
+		if land {
+			stampBlock(data[offset:offset+4096], offset, generation)
+		}
+		if ack {
+			_ = ledger.Ack(dataPath, offset, generation)
+		}
+		return generation
+	}
+
+	// Block 0 is intact, block 1 is reverted to its first generation,
+	// block 2 never landed, and block 3 holds an unacknowledged write.
+	write(0, true, true)
+	old := make([]byte, 4096)
+	write(1, true, true)
+	copy(old, data[4096:8192])
+	write(1, true, true)
+	copy(data[4096:8192], old)
+	write(2, true, false)
+	write(3, true, true)
+	write(3, false, true)
+	if err := ledger.Close(); err != nil {
+		t.Fatalf("Unable to close ledger. %s\n", err)
+	}
+	if err := os.WriteFile(dataPath, data, 0644); err != nil {
+		t.Fatalf("Unable to write data file. %s\n", err)
+	}
+
+	results, err := CheckLedger(ledgerPath, false)
+	if err != nil {
+		t.Fatalf("Unable to check ledger. %s\n", err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("Expected results for 1 file, found %d.\n", len(results))
+	}
+	r := results[0]
+	t.Log(r)
+	if r.Intact != 1 || r.Reverted != 1 || r.Lost != 1 || r.InFlightLanded != 1 || !r.Failed() {
+		t.Errorf("Unexpected check results. %s\n", r)
+	}
+}
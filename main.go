@@ -3,6 +3,7 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"math/rand"
 	"os"
@@ -15,10 +16,16 @@ import (
 	"time"
 )
 
-// TODO: For very long duration tests or extremely fast operations, we should batch write IO latency values
-//const (
-//	StatsWriteBatch int = 1000000
-//)
+// Streams derived from the run seed with deriveSeed
+const (
+	SeedRandomMap int64 = iota + 1
+	SeedPrefill
+	SeedWriterData
+	SeedWriterOffsets
+	SeedReaderOffsets
+	SeedBurnIn
+	SeedDedupePool
+)
 
 var (
 	Debug   bool
@@ -26,7 +33,7 @@ var (
 	Verbose bool
 )
 
-func setupSignalHandler(wc *[]*WriterConfig, rc *[]*ReaderConfig) {
+func setupSignalHandler(wc *[]*WriterConfig, rc *[]*ReaderConfig, output io.Writer) {
 	c := make(chan os.Signal, 2)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM, syscall.SIGUSR1)
 	go func() {
@@ -37,14 +44,14 @@ func setupSignalHandler(wc *[]*WriterConfig, rc *[]*ReaderConfig) {
 				Stop = true
 				return
 			case syscall.SIGUSR1:
-				fmt.Print("Reader Throughput:\n")
+				_, _ = fmt.Fprint(output, "Reader Throughput:\n")
 				for _, v := range *rc {
-					fmt.Printf("  [%d] %s: %s\n", v.ID, v.ReaderPath, humanizeSize(float64(v.ThroughputBytes), false))
+					_, _ = fmt.Fprintf(output, "  [%d] %s: %s\n", v.ID, v.ReaderPath, humanizeSize(float64(v.ThroughputBytes), false))
 				}
 
-				fmt.Print("Writer Throughput:\n")
+				_, _ = fmt.Fprint(output, "Writer Throughput:\n")
 				for _, v := range *wc {
-					fmt.Printf("  [%d] %s: %s\n", v.ID, v.WriterPath, humanizeSize(float64(v.ThroughputBytes), false))
+					_, _ = fmt.Fprintf(output, "  [%d] %s: %s\n", v.ID, v.WriterPath, humanizeSize(float64(v.ThroughputBytes), false))
 				}
 			default:
 				log.Printf("ERROR: Received unhandled signal: %s\n", sig)
@@ -56,31 +63,66 @@ func setupSignalHandler(wc *[]*WriterConfig, rc *[]*ReaderConfig) {
 func main() {
 	var (
 		blockStats       SysStatsCollection
+		consistency      *ConsistencyTable
+		burnInConfig     *BurnInConfig
 		cliBatchSize     int64
 		cliBlockSize     int64
 		cliBufferSize    int
+		cliBurnIn        string
+		cliBurnInState   string
+		cliDedupe        float64
 		cliDirect        bool
 		cliFileCount     int
 		cliFileSize      int64
+		cliInterval      int
+		cliIntervalPath  string
 		cliIOLimit       int64
+		cliLedger        string
+		cliLedgerCheck   bool
 		cliBytePattern   string
+		cliPatternFile   string
+		cliCompress      string
+		cliConsistency   bool
+		cliOutput        string
+		cliOutputFile    string
+		cliPercentiles   string
+		cliPrecision     int
 		cliPrefill       bool
+		cliRaw           bool
 		cliRecordStats   string
+		cliStatsInterval int
 		cliRecordLatency string
+		latencyRecorder  *LatencyRecorder
+		cliScan          string
+		cliScanRegion    int64
+		cliScanRetries   int
+		cliScanThreshold int
 		cliReadPattern   string
 		cliReaders       int
 		cliSeconds       int
+		cliSeed          int64
+		cliUnique        bool
 		cliWritePattern  string
 		cliWriters       int
+		intervalReporter *IntervalReporter
+		ioFileParents    = make(map[string]string)
+		ioPathDevices    = make(map[string]string)
 		ioFiles          []string
 		ioPaths          []string
 		ioStatsResults   *IOStats
 		ioRunTime        time.Duration
 		keep             bool
-		bytePattern      int
+		ledger           *Ledger
+		dataConfig       DataConfig
+		percentiles      []float64
 		randomMap        []int64
 		readerConfigs    []*ReaderConfig
 		readPattern      uint8
+		pageCache        *PageCacheState
+		processIO        *ProcessIO
+		runDuration      time.Duration
+		runStart         time.Time
+		scanConfig       *ScanConfig
 		version          bool
 		wg               sync.WaitGroup
 		writerConfigs    []*WriterConfig
@@ -101,18 +143,40 @@ func main() {
 	flag.Int64Var(&cliBatchSize, "batch", 104857600, "The amount of data each writer should write before calling Sync")
 	flag.Int64Var(&cliBlockSize, "block", 65536, "The size of each IO operation")
 	flag.IntVar(&cliBufferSize, "buffer", 33554432, "Data buffer size for IO operations. Min: 65536.")
+	flag.StringVar(&cliBurnIn, "burnin", "", "Burn-in targets with a full write and verify pass for each comma separated byte pattern, e.g. AA,55,FF,00,random")
+	flag.StringVar(&cliBurnInState, "burnin-state", "", "Record completed burn-in passes in the specified file, and resume from it")
+	flag.StringVar(&cliCompress, "compress", "", "Target compression ratio for written data, e.g. 2:1. Default: incompressible random data")
+	flag.Float64Var(&cliDedupe, "dedupe", 0, "The fraction of written blocks which repeat, from 0 to 1. Implies -unique")
+	flag.BoolVar(&cliConsistency, "consistency", false, "Check that readers observe every write completed before their read started")
 	flag.BoolVar(&cliDirect, "direct", false, "Linux only: Use direct file IO to skip filesystem cache. Default: false")
 	flag.IntVar(&cliFileCount, "files", 1, "The number of files per path")
+	flag.IntVar(&cliInterval, "interval", 0, "Print throughput, IOPS, and latency every N seconds")
+	flag.StringVar(&cliIntervalPath, "interval-path", "", "Save interval reports to the specified path. Default: the -stats path, if any")
 	flag.BoolVar(&keep, "keep", false, "Do not remove data files upon completion")
-	flag.StringVar(&cliBytePattern, "pattern", "random", "The byte pattern for writer routines. One of 55, AA, FF, random, zero.")
+	flag.StringVar(&cliOutput, "output", OutputText, "The format of the results. One of text or json")
+	flag.StringVar(&cliOutputFile, "output-file", "", "Write the JSON report to the specified file instead of stdout")
+	flag.StringVar(&cliBytePattern, "pattern", "random", "The byte pattern for writer routines. One of 55, AA, FF, random, zero, walk0, walk1, or a hex string.")
+	flag.StringVar(&cliPatternFile, "pattern-file", "", "Tile the contents of the specified file into writer data. Overrides -pattern")
+	flag.StringVar(&cliPercentiles, "percentiles", DefaultPercentiles, "Comma separated latency percentiles to report")
+	flag.IntVar(&cliPrecision, "precision", HistogramPrecision, "Latency histogram precision in significant digits, from 1 to 5")
 	flag.BoolVar(&cliPrefill, "prefill", false, "Pre-fill files before performing IO tests.")
+	flag.BoolVar(&cliRaw, "raw", false, "Allow readers and writers on block device targets, destroying their contents")
+	flag.StringVar(&cliLedger, "ledger", "", "Record acknowledged durable writes to the specified ledger file, which should be on a separate device")
+	flag.BoolVar(&cliLedgerCheck, "ledger-check", false, "Check the blocks recorded in the -ledger file after an unclean shutdown, and exit")
 	flag.StringVar(&cliRecordLatency, "latency", "", "Save IO latency statistics to the specified path")
 	flag.StringVar(&cliRecordStats, "stats", "", "Save block device IO statistics to the specified path")
+	flag.IntVar(&cliStatsInterval, "stats-interval", 1000, "Milliseconds between block device IO statistics samples. Min: 10")
 	flag.StringVar(&cliReadPattern, "rpattern", "sequential", "The IO pattern for reader routines")
 	flag.IntVar(&cliReaders, "readers", 0, "The number of reader routines")
+	flag.StringVar(&cliScan, "scan", "", "Scan targets with sequential reads, saving per-region latency and flagged ranges to the specified path")
+	flag.Int64Var(&cliScanRegion, "scan-region", 67108864, "The size of each region in the scan latency output")
+	flag.IntVar(&cliScanRetries, "scan-retries", 2, "The number of times a failed scan read is retried")
+	flag.IntVar(&cliScanThreshold, "scan-threshold", 100, "Scan reads slower than this many milliseconds are flagged")
+	flag.Int64Var(&cliSeed, "seed", 0, "Seed for data content and IO offsets, to replay a previous run. Default: seeded from the clock")
 	flag.IntVar(&cliSeconds, "time", 0, "The number of seconds to run IO routines. Overrides total value")
 	flag.Int64Var(&cliFileSize, "size", 33554432, "The target file size for each IO routine")
 	flag.Int64Var(&cliIOLimit, "total", 33554432, "The total amount of data to read and write per file")
+	flag.BoolVar(&cliUnique, "unique", false, "Stamp every written 4KiB block so it is unique")
 	flag.BoolVar(&Verbose, "verbose", false, "Output extra running messages")
 	flag.BoolVar(&version, "version", false, "Output binary version and exit")
 	flag.StringVar(&cliWritePattern, "wpattern", "sequential", "The IO pattern for writer routines")
@@ -136,7 +200,29 @@ func main() {
 		}
 	}
 
-	if cliReaders == 0 && cliWriters == 0 {
+	if cliLedgerCheck {
+		if cliLedger == "" {
+			log.Println("ERROR: A ledger file must be specified with -ledger to check it.")
+			os.Exit(1)
+		}
+		results, err := CheckLedger(cliLedger, cliDirect)
+		if err != nil {
+			log.Printf("ERROR: Unable to check ledger %s. %s\n", cliLedger, err)
+			os.Exit(1)
+		}
+		failed := false
+		fmt.Println("Ledger check results:")
+		for _, result := range results {
+			fmt.Print(result)
+			failed = failed || result.Failed()
+		}
+		if failed {
+			os.Exit(3)
+		}
+		os.Exit(0)
+	}
+
+	if cliReaders == 0 && cliWriters == 0 && cliBurnIn == "" && cliScan == "" {
 		log.Println("ERROR: At least 1 reader or writer must be executed.")
 		os.Exit(1)
 
@@ -159,22 +245,116 @@ func main() {
 	}
 	ioPaths = uniquePaths(flag.Args())
 
-	// TODO: Interpret bytePattern flag (55, AA, FF, random, zero)
-	cliBytePattern = strings.ToLower(cliBytePattern)
-	switch cliBytePattern {
-	case "zero", "00":
-		bytePattern = PatternZero
-	case "random":
-		bytePattern = PatternRand
-	case "55":
-		bytePattern = Pattern55
-	case "aa":
-		bytePattern = PatternAA
-	case "ff":
-		bytePattern = PatternFF
-	default:
-		log.Printf("ERROR: Byte pattern must be 55, AA, FF, random, or zero. %s is invalid.\n", cliBytePattern)
+	// Zero is a valid seed, so only seed from the clock when -seed was not given.
+	seedSet := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "seed" {
+			seedSet = true
+		}
+	})
+	if !seedSet {
+		cliSeed = time.Now().UnixNano()
+	}
+	log.Printf("Seed: %d\n", cliSeed)
+
+	if p, custom, err := parsePattern(cliBytePattern); err != nil {
+		log.Printf("ERROR: %s.\n", err)
 		os.Exit(1)
+	} else {
+		dataConfig = DataConfig{Custom: custom, Pattern: p, Seed: cliSeed, Unique: cliUnique}
+	}
+
+	if cliPatternFile != "" {
+		custom, err := loadPattern(cliPatternFile)
+		if err != nil {
+			log.Printf("ERROR: Unable to load pattern file. %s\n", err)
+			os.Exit(1)
+		}
+		// Writer buffers are rounded up to whole patterns, so a larger file would grow every buffer to its size.
+		if len(custom) > cliBufferSize {
+			log.Printf("ERROR: The pattern file is %d bytes, larger than the %d byte -buffer. Increase -buffer or use a smaller pattern file.\n", len(custom), cliBufferSize)
+			os.Exit(1)
+		}
+		dataConfig.Pattern = PatternCustom
+		dataConfig.Custom = custom
+	}
+
+	if cliDedupe < 0 || cliDedupe > 1 {
+		log.Printf("ERROR: The dedupe ratio must be between 0 and 1. %0.2f is invalid.\n", cliDedupe)
+		os.Exit(1)
+	}
+	dataConfig.Dedupe = cliDedupe
+	dataConfig.DedupeSeed = deriveSeed(cliSeed, SeedDedupePool)
+
+	if cliCompress != "" {
+		ratio, err := parseCompression(cliCompress)
+		if err != nil {
+			log.Printf("ERROR: %s.\n", err)
+			os.Exit(1)
+		}
+		dataConfig.Compression = ratio
+	}
+	if dataConfig.Compression > 1 {
+		sample := NewDataReaderFromConfig(cliBufferSize, dataConfig)
+		log.Printf(
+			"Data compressibility: target %0.2f:1, measured %0.2f:1 (DEFLATE)\n",
+			dataConfig.Compression, compressionRatio(sample.data),
+		)
+	} else if Verbose {
+		sample := NewDataReaderFromConfig(cliBufferSize, dataConfig)
+		log.Printf("Data compressibility: measured %0.2f:1 (DEFLATE)\n", compressionRatio(sample.data))
+	}
+
+	if cliBurnIn != "" {
+		patterns, err := parsePatternList(cliBurnIn)
+		if err != nil {
+			log.Printf("ERROR: Invalid burn-in pattern list. %s.\n", err)
+			os.Exit(1)
+		}
+		burnInConfig = &BurnInConfig{
+			BlockSize:  cliBlockSize,
+			BufferSize: cliBufferSize,
+			Direct:     cliDirect,
+			FileSize:   cliFileSize,
+			Patterns:   patterns,
+			Seed:       cliSeed,
+			StatePath:  cliBurnInState,
+		}
+	}
+
+	if cliScan != "" {
+		if cliBurnIn != "" {
+			log.Println("ERROR: Burn-in and scan modes can not be combined.")
+			os.Exit(1)
+		}
+		if fInfo, fErr := os.Stat(cliScan); os.IsNotExist(fErr) {
+			log.Printf("ERROR: Scan output path %s does not exist.\n", cliScan)
+			os.Exit(1)
+		} else if fErr != nil && !os.IsNotExist(fErr) {
+			log.Printf("ERROR: Unable to access scan output path %s. %s\n", cliScan, fErr)
+			os.Exit(1)
+		} else if !fInfo.IsDir() {
+			log.Println("ERROR: Scan output path is not a directory.")
+			os.Exit(1)
+		}
+		if cliScanRegion < cliBlockSize {
+			log.Println("ERROR: The scan region size must be at least the block size.")
+			os.Exit(1)
+		}
+		if cliScanRetries < 0 {
+			log.Printf("ERROR: The number of scan retries must not be negative. %d is invalid.\n", cliScanRetries)
+			os.Exit(1)
+		}
+
+		scanConfig = &ScanConfig{
+			BlockSize:  cliBlockSize,
+			Direct:     cliDirect,
+			FileSize:   cliFileSize,
+			OutputPath: cliScan,
+			RegionSize: cliScanRegion,
+			Retries:    cliScanRetries,
+			Threshold:  time.Millisecond * time.Duration(cliScanThreshold),
+		}
 	}
 
 	cliReadPattern = strings.ToLower(cliReadPattern)
@@ -216,7 +396,7 @@ func main() {
 		if Debug {
 			log.Println("Shuffling random map entries.")
 		}
-		rand.Shuffle(len(randomMap), func(i, j int) {
+		rand.New(rand.NewSource(deriveSeed(cliSeed, SeedRandomMap))).Shuffle(len(randomMap), func(i, j int) {
 			randomMap[i], randomMap[j] = randomMap[j], randomMap[i]
 		})
 	}
@@ -226,6 +406,32 @@ func main() {
 		cliRecordStats = ""
 	}
 
+	if cliPrecision < 1 || cliPrecision > 5 {
+		log.Printf("ERROR: Latency precision must be from 1 to 5 digits. %d is invalid.\n", cliPrecision)
+		os.Exit(1)
+	}
+
+	if cliOutput != OutputText && cliOutput != OutputJSON {
+		log.Printf("ERROR: Unknown output format %s. Use text or json.\n", cliOutput)
+		os.Exit(1)
+	}
+	if cliOutput == OutputJSON && (cliBurnIn != "" || cliScan != "") {
+		log.Println("ERROR: JSON output is not supported with -burnin or -scan.")
+		os.Exit(1)
+	}
+
+	if p, err := parsePercentiles(cliPercentiles); err != nil {
+		log.Printf("ERROR: Unable to parse percentiles. %s\n", err)
+		os.Exit(1)
+	} else {
+		percentiles = p
+	}
+
+	if cliInterval < 0 {
+		log.Printf("ERROR: The reporting interval must not be negative. %d is invalid.\n", cliInterval)
+		os.Exit(1)
+	}
+
 	if cliBlockSize < 4096 {
 		log.Println("WARNING: Block sizes below 4k are probably nonsense to test.")
 	}
@@ -242,7 +448,38 @@ func main() {
 			os.Exit(1)
 		}
 
-		blockStats = SysStatsCollection{Semaphore: statsStopper}
+		if time.Duration(cliStatsInterval)*time.Millisecond < MinStatsInterval {
+			log.Printf("ERROR: The stats interval must be at least %d milliseconds.\n", MinStatsInterval.Milliseconds())
+			os.Exit(1)
+		}
+		blockStats = SysStatsCollection{
+			CPU:        &cpuStats{},
+			Interrupts: &interruptStats{},
+			Interval:   time.Duration(cliStatsInterval) * time.Millisecond,
+			Memory:     &memoryStats{},
+			Pressure:   newPressureStats(),
+			Runtime:    &runtimeStats{},
+			Semaphore:  statsStopper,
+			VM:         &vmStats{},
+		}
+	}
+
+	if cliIntervalPath != "" {
+		if fInfo, fErr := os.Stat(cliIntervalPath); os.IsNotExist(fErr) {
+			log.Printf("ERROR: Interval stats path %s does not exist.\n", cliIntervalPath)
+			os.Exit(1)
+		} else if fErr != nil && !os.IsNotExist(fErr) {
+			log.Printf("ERROR: Unable to access interval stats path %s. %s\n", cliIntervalPath, fErr)
+			os.Exit(1)
+		} else if !fInfo.IsDir() {
+			log.Println("ERROR: Interval stats path is not a directory.")
+			os.Exit(1)
+		}
+		if cliInterval == 0 {
+			log.Println("WARNING: No interval reports are saved without -interval.")
+		}
+	} else {
+		cliIntervalPath = cliRecordStats
 	}
 
 	if cliRecordLatency != "" {
@@ -257,10 +494,55 @@ func main() {
 			os.Exit(1)
 		}
 
-		log.Println("Setting up latency struct")
-		ioStatsResults = new(IOStats)
-		ioStatsResults.ReadThroughput = make(map[string][]*Throughput)
-		ioStatsResults.WriteThroughput = make(map[string][]*Throughput)
+	}
+
+	ioStatsResults = new(IOStats)
+	ioStatsResults.ReadThroughput = make(map[string][]*Throughput)
+	ioStatsResults.WriteThroughput = make(map[string][]*Throughput)
+
+	if cliLedger != "" {
+		if cliBurnIn != "" || cliScan != "" {
+			log.Println("ERROR: A ledger can not be recorded in burn-in or scan modes.")
+			os.Exit(1)
+		}
+		if cliWriters > 1 && writePattern != Sequential {
+			log.Println("WARNING: Concurrent writers may overwrite the same block, which can make the ledger check report reverted blocks.")
+		}
+		ledgerDev := DevFromPath(path.Dir(cliLedger))
+		for _, ioPath := range ioPaths {
+			if ledgerDev != "" && DevFromPath(ioPath) == ledgerDev {
+				log.Printf("WARNING: The ledger is on the same device as %s, so it may not survive a power loss either.\n", ioPath)
+			}
+		}
+
+		var err error
+		if ledger, err = OpenLedger(cliLedger, cliBlockSize); err != nil {
+			log.Printf("ERROR: Unable to create ledger %s. %s\n", cliLedger, err)
+			os.Exit(1)
+		}
+		if !keep {
+			log.Println("Keeping data files, so they can be checked against the ledger.")
+			keep = true
+		}
+	}
+
+	if cliConsistency {
+		if cliBurnIn != "" || cliScan != "" {
+			log.Println("ERROR: Consistency checks can not be combined with burn-in or scan modes.")
+			os.Exit(1)
+		}
+		if cliReaders == 0 || cliWriters == 0 {
+			log.Println("WARNING: Consistency checks need both readers and writers on the same files.")
+		}
+		consistency = NewConsistencyTable(cliBlockSize)
+	}
+
+	for _, ioPath := range ioPaths {
+		// Burn-in and scan modes are meant for whole devices, but readers and writers would overwrite one.
+		if isBlockDevice(ioPath) && burnInConfig == nil && scanConfig == nil && !cliRaw {
+			log.Printf("ERROR: %s is a block device. Use -raw to run readers and writers on it, destroying its contents.\n", ioPath)
+			os.Exit(1)
+		}
 	}
 
 	ioRunTime = 0
@@ -272,8 +554,12 @@ func main() {
 		cliIOLimit = 0
 	}
 
-	// Wait for CTRL+C in the background
-	setupSignalHandler(&writerConfigs, &readerConfigs)
+	// Wait for CTRL+C in the background, keeping stdout for the JSON report alone
+	var signalOutput io.Writer = os.Stdout
+	if cliOutput == OutputJSON && cliOutputFile == "" {
+		signalOutput = os.Stderr
+	}
+	setupSignalHandler(&writerConfigs, &readerConfigs, signalOutput)
 
 	log.Println("Creating files")
 	for _, ioPath := range ioPaths {
@@ -281,14 +567,22 @@ func main() {
 			os.Exit(0)
 		}
 		// Add the path to the IO stats collector list
+		ioPathDevices[ioPath] = DevFromPath(ioPath)
 		if cliRecordStats != "" {
-			blockStats.Add(DevFromPath(ioPath))
+			blockStats.Add(ioPathDevices[ioPath])
+		}
+
+		// Since we can't create files on block devices, just use the device once
+		if isBlockDevice(ioPath) {
+			ioFileParents[ioPath] = ioPath
+			ioFiles = append(ioFiles, ioPath)
+			continue
 		}
 
 		for j := 0; j < cliFileCount; j++ {
-			// Since we can't create files on raw devices, just use the raw device
-			// TODO: Add generic block device detection
+			// The null and zero devices can't hold files either, but cost nothing to use once per file
 			if ioPath == "/dev/null" || ioPath == "/dev/zero" {
+				ioFileParents[ioPath] = ioPath
 				ioFiles = append(ioFiles, ioPath)
 				continue
 			}
@@ -302,87 +596,212 @@ func main() {
 				log.Printf("ERROR: Unable to allocate %s. %s", filePath, allocErr)
 				os.Exit(2)
 			}
-			if cliPrefill {
+			if cliPrefill && burnInConfig == nil && scanConfig == nil {
 				wg.Add(1)
-				go prefill(filePath, cliFileSize, bytePattern, &wg)
+				prefillData := dataConfig
+				prefillData.Seed = deriveSeed(cliSeed, SeedPrefill, int64(len(ioFiles)))
+				go prefill(filePath, cliFileSize, prefillData, &wg)
 			}
 
+			ioFileParents[filePath] = ioPath
 			ioFiles = append(ioFiles, filePath)
 		}
 	}
 	wg.Wait()
 	dropPageCache()
 
+	// Read the environment before the run starts, so it is not counted in the run's duration or statistics.
+	environment := readEnvironment(ioPaths)
+
+	// Statistics, latency records, and interval reports are all timed from the start of the run.
+	runStart = time.Now()
+	startIO, err := readProcessIO()
+	if err != nil && Verbose {
+		log.Printf("Unable to read process IO accounting. %s\n", err)
+	}
 	if cliRecordStats != "" {
+		blockStats.Start = runStart
 		go blockStats.CollectStats()
 	}
 
-	log.Println("Starting io routines")
-	for _, ioFile := range ioFiles {
-		if ioFile != "/dev/zero" {
-			if Verbose {
-				log.Printf("[%s] Starting %d writers\n", ioFile, cliWriters)
+	var burnInResults [][]*burnInResult
+	var scanResults []*scanResult
+	if burnInConfig != nil {
+		burnInConfig.Files = ioFiles
+		burnInResults = burnIn(burnInConfig)
+	} else if scanConfig != nil {
+		scanConfig.Files = ioFiles
+		scanResults = scan(scanConfig)
+	} else {
+		if consistency != nil {
+			for _, ioFile := range ioFiles {
+				consistency.Add(ioFile, cliFileSize)
+			}
+		}
+
+		if cliRecordLatency != "" {
+			var err error
+			if latencyRecorder, err = NewLatencyRecorder(cliRecordLatency, runStart); err != nil {
+				log.Printf("ERROR: Unable to create latency stats files. %s\n", err)
+				os.Exit(1)
 			}
-			for i := 0; i < cliWriters; i++ {
-				wc := WriterConfig{
-					ID:          i,
-					BatchSize:   cliBatchSize,
-					BlockSize:   cliBlockSize,
-					BufferSize:  cliBufferSize,
-					BytePattern: bytePattern,
-					Direct:      cliDirect,
-					FileSize:    cliFileSize,
-					RandomMap:   &randomMap,
-					StartOffset: cliFileSize / int64(cliWriters) * int64(i),
-					WriteLimit:  cliIOLimit,
-					WriteTime:   ioRunTime,
-					WriterPath:  ioFile,
-					WriterType:  writePattern,
-					Results:     ioStatsResults,
+		}
+
+		if cliInterval > 0 {
+			intervalReporter = NewIntervalReporter(time.Duration(cliInterval)*time.Second, cliPrecision)
+			if cliOutput == OutputJSON && cliOutputFile == "" {
+				// Keep stdout for the JSON report alone.
+				intervalReporter.Output = os.Stderr
+			}
+		}
+
+		log.Println("Starting io routines")
+		for fileIndex, ioFile := range ioFiles {
+			if ioFile != "/dev/zero" {
+				if Verbose {
+					log.Printf("[%s] Starting %d writers\n", ioFile, cliWriters)
 				}
-				writerConfigs = append(writerConfigs, &wc)
-				wg.Add(1)
-				go writer(&wc, &wg)
+				for i := 0; i < cliWriters; i++ {
+					writerData := dataConfig
+					writerData.Seed = deriveSeed(cliSeed, SeedWriterData, int64(fileIndex), int64(i))
+					wc := WriterConfig{
+						ID:          i,
+						Ledger:      ledger,
+						BatchSize:   cliBatchSize,
+						BlockSize:   cliBlockSize,
+						BufferSize:  cliBufferSize,
+						Consistency: consistency,
+						Data:        writerData,
+						Direct:      cliDirect,
+						FileSize:    cliFileSize,
+						Histogram:   NewHistogram(cliPrecision),
+						RandomMap:   &randomMap,
+						Seed:        deriveSeed(cliSeed, SeedWriterOffsets, int64(fileIndex), int64(i)),
+						StartOffset: alignDown(cliFileSize/int64(cliWriters)*int64(i), cliBlockSize),
+						WriteLimit:  cliIOLimit,
+						WriteTime:   ioRunTime,
+						WriterPath:  ioFile,
+						WriterType:  writePattern,
+						Recorder:    latencyRecorder,
+						Results:     ioStatsResults,
+					}
+					if intervalReporter != nil {
+						wc.Interval = intervalReporter.NewCounter(OpWrite, ioFileParents[ioFile], ioFile, i)
+					}
+					if blockStats.Runtime != nil {
+						wc.Outliers = NewLatencyOutliers(OpWrite, i)
+					}
+					writerConfigs = append(writerConfigs, &wc)
+					wg.Add(1)
+					go writer(&wc, &wg)
+				}
+			} else {
+				log.Println("Skipping writers for /dev/zero")
+			}
+
+			if ioFile != "/dev/null" {
+				if Verbose {
+					log.Printf("[%s] Starting %d readers\n", ioFile, cliReaders)
+				}
+				for i := 0; i < cliReaders; i++ {
+					rc := ReaderConfig{
+						ID:          i,
+						BlockSize:   cliBlockSize,
+						BytePattern: dataConfig.Pattern,
+						Consistency: consistency,
+						Direct:      cliDirect,
+						FileSize:    cliFileSize,
+						Histogram:   NewHistogram(cliPrecision),
+						RandomMap:   &randomMap,
+						ReadLimit:   cliIOLimit,
+						ReadTime:    ioRunTime,
+						ReaderPath:  ioFile,
+						ReaderType:  readPattern,
+						Recorder:    latencyRecorder,
+						Results:     ioStatsResults,
+						Seed:        deriveSeed(cliSeed, SeedReaderOffsets, int64(fileIndex), int64(i)),
+						StartOffset: alignDown(cliFileSize/int64(cliReaders)*int64(i), cliBlockSize),
+					}
+					if intervalReporter != nil {
+						rc.Interval = intervalReporter.NewCounter(OpRead, ioFileParents[ioFile], ioFile, i)
+					}
+					if blockStats.Runtime != nil {
+						rc.Outliers = NewLatencyOutliers(OpRead, i)
+					}
+					readerConfigs = append(readerConfigs, &rc)
+					wg.Add(1)
+					go reader(&rc, &wg)
+				}
+			} else {
+				log.Println("Skipping readers for /dev/null")
+			}
+		}
+		if intervalReporter != nil {
+			go intervalReporter.Run(runStart)
+		}
+		wg.Wait()
+		runDuration = time.Now().Sub(runStart)
+		if cliRecordStats != "" {
+			// Take the last sample as soon as IO stops, so it does not count saving results or cleanup.
+			blockStats.Stop()
+		}
+		if cliWriters > 0 {
+			// Buffered writers finish as soon as their data is in the page cache, so record how much of it
+			// had not reached the devices yet.
+			if pageCache, err = readPageCacheState(); err != nil && Verbose {
+				log.Printf("Unable to read the page cache state. %s\n", err)
+			}
+		}
+		if stopIO, err := readProcessIO(); startIO != nil && err == nil {
+			processIO = stopIO.Sub(startIO)
+		}
+		if blockStats.Runtime != nil {
+			var outliers []*LatencyOutliers
+			for _, rc := range readerConfigs {
+				outliers = append(outliers, rc.Outliers)
+			}
+			for _, wc := range writerConfigs {
+				outliers = append(outliers, wc.Outliers)
 			}
-		} else {
-			log.Println("Skipping writers for /dev/zero")
+			blockStats.Runtime.Outliers = worstOutliers(outliers...)
 		}
 
-		if ioFile != "/dev/null" {
+		if intervalReporter != nil {
+			intervalReporter.Stop()
+			if cliIntervalPath != "" {
+				if err := intervalReporter.Write(cliIntervalPath); err != nil {
+					log.Printf("ERROR: Unable to save interval stats. %s\n", err)
+				}
+			}
+		}
+
+		if latencyRecorder != nil {
 			if Verbose {
-				log.Printf("[%s] Starting %d readers\n", ioFile, cliReaders)
+				log.Println("Saving latency stats")
 			}
-			for i := 0; i < cliReaders; i++ {
-				rc := ReaderConfig{
-					ID:          i,
-					BlockSize:   cliBlockSize,
-					BytePattern: bytePattern,
-					Direct:      cliDirect,
-					FileSize:    cliFileSize,
-					RandomMap:   &randomMap,
-					ReadLimit:   cliIOLimit,
-					ReadTime:    ioRunTime,
-					ReaderPath:  ioFile,
-					ReaderType:  readPattern,
-					Results:     ioStatsResults,
-					StartOffset: cliFileSize / int64(cliReaders) * int64(i),
-				}
-				readerConfigs = append(readerConfigs, &rc)
-				wg.Add(1)
-				go reader(&rc, &wg)
+			if err := latencyRecorder.Close(); err != nil {
+				log.Printf("ERROR: Unable to save IO latency stats. %s\n", err)
 			}
-		} else {
-			log.Println("Skipping readers for /dev/null")
 		}
 	}
-	wg.Wait()
+
+	if cliRecordStats != "" && (burnInConfig != nil || scanConfig != nil) {
+		// Take the last sample before cleanup, so it does not count IO from removing files.
+		blockStats.Stop()
+	}
+
+	if ledger != nil {
+		if err := ledger.Close(); err != nil {
+			log.Printf("ERROR: Unable to close ledger %s. %s\n", cliLedger, err)
+		}
+	}
 
 	if !keep {
 		if Verbose {
 			log.Println("Cleaning up test files.")
 		}
 		for _, ioFile := range ioFiles {
-			if ioFile == "/dev/null" || ioFile == "/dev/zero" {
+			if ioFile == "/dev/null" || ioFile == "/dev/zero" || isBlockDevice(ioFile) {
 				continue
 			}
 			if err := os.Remove(ioFile); err != nil {
@@ -395,57 +814,150 @@ func main() {
 		if Verbose {
 			log.Println("Saving block IO stats")
 		}
-		statsStopper <- true
 
 		if err := blockStats.Write(cliRecordStats); err != nil {
 			log.Printf("ERROR: An error occurred saving block IO stats. %s\n", err)
 		}
+		if err := blockStats.WriteMetrics(cliRecordStats); err != nil {
+			log.Printf("ERROR: An error occurred saving block IO metrics. %s\n", err)
+		}
+		if err := blockStats.WriteSystem(cliRecordStats); err != nil {
+			log.Printf("ERROR: An error occurred saving system stats. %s\n", err)
+		}
+
+		if cliOutput == OutputText {
+			fmt.Println("Device statistics:")
+			for _, d := range blockStats.Disk {
+				if d.Info != nil {
+					fmt.Print(d.Info)
+				}
+				fmt.Print(d.Summary())
+				if t := d.TemperatureSummary(); t != nil {
+					fmt.Print(t)
+				}
+			}
+			fmt.Println("System statistics:")
+			fmt.Print(blockStats.SystemSummary())
+		}
+	}
+
+	if burnInConfig != nil {
+		fmt.Println("Burn-in results:")
+		for _, passResults := range burnInResults {
+			for _, result := range passResults {
+				fmt.Printf("Pass %d (%s):\n%s", result.Pass, result.Pattern.PatternName(), result)
+			}
+		}
+		return
+	}
+
+	if scanConfig != nil {
+		fmt.Println("Scan results:")
+		for _, result := range scanResults {
+			fmt.Print(result)
+			if err := result.Write(scanConfig.OutputPath); err != nil {
+				log.Printf("ERROR: Unable to save scan results for %s. %s\n", result.Path, err)
+			}
+		}
+		return
+	}
+
+	readTree, err := groupThroughput(ioStatsResults.ReadThroughput, ioFiles, ioFileParents, ioPathDevices)
+	if err != nil {
+		log.Printf("ERROR: Unable to summarize reader results. %s\n", err)
+		os.Exit(1)
+	}
+	writeTree, err := groupThroughput(ioStatsResults.WriteThroughput, ioFiles, ioFileParents, ioPathDevices)
+	if err != nil {
+		log.Printf("ERROR: Unable to summarize writer results. %s\n", err)
+		os.Exit(1)
+	}
+
+	if cliOutput == OutputJSON {
+		report := &Report{
+			DiskStats:   []*ReportDiskStats{},
+			Duration:    runDuration.Seconds(),
+			Environment: environment,
+			Parameters: ReportParameters{
+				BatchSize:    cliBatchSize,
+				BlockSize:    cliBlockSize,
+				BufferSize:   cliBufferSize,
+				Compression:  dataConfig.Compression,
+				Consistency:  consistency != nil,
+				Dedupe:       dataConfig.Dedupe,
+				Direct:       cliDirect,
+				Files:        cliFileCount,
+				FileSize:     cliFileSize,
+				Paths:        ioPaths,
+				Pattern:      dataConfig.PatternName(),
+				PatternFile:  cliPatternFile,
+				Percentiles:  percentiles,
+				Prefill:      cliPrefill,
+				ReadPattern:  cliReadPattern,
+				Readers:      cliReaders,
+				Seconds:      cliSeconds,
+				Seed:         cliSeed,
+				Total:        cliIOLimit,
+				Unique:       dataConfig.Unique,
+				WritePattern: cliWritePattern,
+				Writers:      cliWriters,
+			},
+			Schema:  ReportSchemaVersion,
+			Start:   runStart,
+			Version: newReportVersion(),
+		}
+		report.Read = newReportResults(readTree, percentiles)
+		report.Write = newReportResults(writeTree, percentiles)
+		report.PageCache = pageCache
+		if consistency != nil {
+			for _, rc := range readerConfigs {
+				report.Consistency = append(report.Consistency, newReportConsistency(rc))
+			}
+		}
+		report.ProcessIO = processIO
+		for _, d := range blockStats.Disk {
+			report.DiskStats = append(report.DiskStats, newReportDiskStats(d))
+		}
+		if blockStats.CPU != nil {
+			report.CPU = blockStats.CPU.Summarize()
+		}
+		if blockStats.Interrupts != nil {
+			report.Interrupts = blockStats.Interrupts.Summarize()
+		}
+		if blockStats.Pressure != nil {
+			report.Pressure = blockStats.Pressure.Summarize()
+		}
+		if blockStats.Runtime != nil {
+			report.Runtime = blockStats.Runtime.Summarize()
+		}
+		if err := report.Save(cliOutputFile); err != nil {
+			log.Printf("ERROR: Unable to save the JSON report. %s\n", err)
+			os.Exit(1)
+		}
+		return
 	}
 
+	fmt.Printf("Seed: %d\n", cliSeed)
+	fmt.Print(environment)
+
 	// Output reader routine throughputs
 	fmt.Println("Reader performance:")
-	//pathThroughputTotals := make(map[string]float64)
-	pathThroughputGrandTotal := 0.0
-	for _, rc := range readerConfigs {
-		fmt.Printf("[%d] %s: %0.2f MiB/sec.\n", rc.ID, rc.ReaderPath, float64(rc.ThroughputBytes)/MiB/rc.ThroughputTime.Seconds())
-		//pathThroughputTotals[rc.ReaderPath] = float64(rc.ThroughputBytes) / MiB / rc.ThroughputTime.Seconds()
-		pathThroughputGrandTotal += float64(rc.ThroughputBytes) / MiB / rc.ThroughputTime.Seconds()
-		//for _, v := range ioPaths {
-		//	if strings.HasPrefix(rc.ReaderPath, v) {
-		//		pathThroughputTotals[v] += float64(rc.ThroughputBytes) / MiB / rc.ThroughputTime.Seconds()
-		//	}
-		//}
-	}
-	//for k, v := range pathThroughputTotals {
-	//	fmt.Printf("%s: %0.2f MiB/sec.\n", k, v)
-	//}
-	fmt.Printf("Read Total: %0.2f MiB/sec.\n", pathThroughputGrandTotal)
+	fmt.Print(readTree.Summary("Read Total", percentiles))
+
+	if consistency != nil {
+		fmt.Println("Reader consistency:")
+		for _, rc := range readerConfigs {
+			fmt.Printf("[%d] %s: %s\n", rc.ID, rc.ReaderPath, &rc.Violations)
+		}
+	}
 
 	// Output writer routine throughputs
 	fmt.Println("Writer performance:")
-	//pathThroughputTotals = make(map[string]float64)
-	pathThroughputGrandTotal = 0.0
-	for _, wc := range writerConfigs {
-		fmt.Printf("[%d] %s: %0.2f MiB/sec.\n", wc.ID, wc.WriterPath, float64(wc.ThroughputBytes)/MiB/wc.ThroughputTime.Seconds())
-		//pathThroughputTotals[wc.WriterPath] = float64(wc.ThroughputBytes) / MiB / wc.ThroughputTime.Seconds()
-		pathThroughputGrandTotal += float64(wc.ThroughputBytes) / MiB / wc.ThroughputTime.Seconds()
-		//for _, v := range ioPaths {
-		//	//if strings.HasPrefix(wc.WriterPath, v) {
-		//	//	pathThroughputTotals[v] += float64(wc.ThroughputBytes) / MiB / wc.ThroughputTime.Seconds()
-		//	//}
-		//}
-	}
-	//for k, v := range pathThroughputTotals {
-	//	fmt.Printf("%s: %0.2f MiB/sec.\n", k, v)
-	//}
-	fmt.Printf("Write Total: %0.2f MiB/sec.\n", pathThroughputGrandTotal)
-
-	if cliRecordLatency != "" && ioStatsResults != nil {
-		if Verbose {
-			log.Println("Saving latency stats")
-		}
-		if err := ioStatsResults.Write(cliRecordLatency); err != nil {
-			log.Printf("ERROR: Unable to save IO latency stats. %s\n", err)
-		}
+	fmt.Print(writeTree.Summary("Write Total", percentiles))
+	if pageCache != nil {
+		fmt.Printf("Page cache when writers finished: %s\n", pageCache)
+	}
+	if processIO != nil {
+		fmt.Print(processIO)
 	}
 }
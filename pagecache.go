@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// VMStatFields are the /proc/vmstat counters recorded in each vmstat sample. They show how much data
+// sits in the page cache waiting for writeback, and how often writers were throttled to let it drain.
+var VMStatFields = []string{
+	"nr_dirty", "nr_writeback", "nr_dirty_threshold", "nr_dirty_background_threshold",
+	"pgpgin", "pgpgout", "nr_throttled_written", "pgscan_direct_throttle",
+}
+
+// vmstatThrottleFields are the cumulative VMStatFields counting throttling events.
+var vmstatThrottleFields = []string{"nr_throttled_written", "pgscan_direct_throttle"}
+
+type vmstatSample struct {
+	Offset time.Duration
+	Values map[string]int64 // Raw counters, keyed by /proc/vmstat field
+}
+
+type vmStats struct {
+	Samples []*vmstatSample
+}
+
+// PageCacheState is the amount of data in the page cache which had not reached the devices yet.
+type PageCacheState struct {
+	Dirty     int64 `json:"dirty_bytes"`     // Bytes modified in memory and not yet written
+	Writeback int64 `json:"writeback_bytes"` // Bytes being written to the devices
+}
+
+func (s *PageCacheState) String() string {
+	return fmt.Sprintf("%s dirty, %s under writeback", humanizeSize(float64(s.Dirty), true), humanizeSize(float64(s.Writeback), true))
+}
+
+// readPageCacheState returns the dirty and writeback bytes currently in the page cache.
+func readPageCacheState() (*PageCacheState, error) {
+	values, err := readMemInfo()
+	if err != nil {
+		return nil, err
+	}
+	return &PageCacheState{Dirty: values["Dirty"], Writeback: values["Writeback"]}, nil
+}
+
+// readVMStat parses /proc/vmstat, returning every counter by name.
+func readVMStat() (map[string]int64, error) {
+	values := make(map[string]int64)
+
+	vmFile, err := os.Open(path.Join(ProcRoot, "vmstat"))
+	if err != nil {
+		return nil, err
+	}
+	defer vmFile.Close()
+
+	scanner := bufio.NewScanner(vmFile)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		value, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		values[fields[0]] = value
+	}
+	return values, scanner.Err()
+}
+
+func (s *vmStats) UpdateStats(start time.Time) error {
+	values, err := readVMStat()
+	if err != nil {
+		return err
+	}
+	s.Samples = append(s.Samples, &vmstatSample{Offset: time.Now().Sub(start), Values: values})
+	return nil
+}
+
+func (s *vmStats) Csv() string {
+	var output strings.Builder
+
+	output.WriteString("\"time ms\"")
+	for _, field := range VMStatFields {
+		fmt.Fprintf(&output, ",\"%s\"", field)
+	}
+	output.WriteString("\n")
+
+	for _, sample := range s.Samples {
+		fmt.Fprintf(&output, "%d", sample.Offset.Milliseconds())
+		for _, field := range VMStatFields {
+			fmt.Fprintf(&output, ",%d", sample.Values[field])
+		}
+		output.WriteString("\n")
+	}
+	return output.String()
+}
+
+// Summary returns the data paged in and out during the run, and the throttling events which occurred.
+func (s *vmStats) Summary() string {
+	if len(s.Samples) < 2 {
+		return "VM: Not enough samples\n"
+	}
+	first, last := s.Samples[0].Values, s.Samples[len(s.Samples)-1].Values
+
+	// pgpgin and pgpgout count KiB regardless of the page size.
+	output := fmt.Sprintf(
+		"VM:\n  Paged in: %s, paged out: %s\n",
+		humanizeSize(counterDelta(int(first["pgpgin"]), int(last["pgpgin"]))*KiB, true),
+		humanizeSize(counterDelta(int(first["pgpgout"]), int(last["pgpgout"]))*KiB, true),
+	)
+	var throttles []string
+	for _, field := range vmstatThrottleFields {
+		if _, ok := last[field]; ok {
+			throttles = append(throttles, fmt.Sprintf("%s %0.0f", field, counterDelta(int(first[field]), int(last[field]))))
+		}
+	}
+	if len(throttles) > 0 {
+		output += fmt.Sprintf("  Throttling: %s\n", strings.Join(throttles, ", "))
+	}
+	return output
+}
+
+// PageCacheSummary returns the peak dirty and writeback bytes of the memory samples.
+func (s *memoryStats) PageCacheSummary() string {
+	if len(s.Samples) == 0 {
+		return "Page cache: Not enough samples\n"
+	}
+	peak := &PageCacheState{}
+	for _, sample := range s.Samples {
+		if sample.Values["Dirty"] > peak.Dirty {
+			peak.Dirty = sample.Values["Dirty"]
+		}
+		if sample.Values["Writeback"] > peak.Writeback {
+			peak.Writeback = sample.Values["Writeback"]
+		}
+	}
+	return fmt.Sprintf("Page cache:\n  Peak: %s\n", peak)
+}
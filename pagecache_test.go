@@ -0,0 +1,43 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVMStats(t *testing.T) {
+	fakeRoot(t, map[string]string{
+		"proc/meminfo": "MemTotal:       16384 kB\nDirty:           2048 kB\nWriteback:        512 kB\n",
+		"proc/vmstat":  "nr_dirty 512\nnr_writeback 128\npgpgin 1024\npgpgout 2048\nnr_throttled_written 3\n",
+	})
+	start := time.Now()
+
+	state, err := readPageCacheState()
+	if err != nil || state.Dirty != 2*MiB || state.Writeback != 512*KiB {
+		t.Fatalf("Unexpected page cache state %+v. %v\n", state, err)
+	}
+	if s := state.String(); s != "2.00 MB dirty, 512.00 KB under writeback" {
+		t.Errorf("Unexpected page cache state %q\n", s)
+	}
+
+	s := &vmStats{}
+	if err := s.UpdateStats(start); err != nil {
+		t.Fatalf("Unable to read vmstat. %s\n", err)
+	}
+	if csv := s.Csv(); !strings.HasSuffix(csv, ",512,128,0,0,1024,2048,3,0\n") {
+		t.Errorf("Unexpected vmstat CSV %q\n", csv)
+	}
+
+	s.Samples = append(s.Samples, &vmstatSample{Offset: time.Second, Values: map[string]int64{
+		"pgpgin": 1024, "pgpgout": 2048 + 10*1024, "nr_throttled_written": 5,
+	}})
+	summary := s.Summary()
+	if !strings.Contains(summary, "paged out: 10.00 MB") || !strings.Contains(summary, "nr_throttled_written 2") {
+		t.Errorf("Unexpected vmstat summary %q\n", summary)
+	}
+	// Counters the kernel does not provide are not reported.
+	if strings.Contains(summary, "pgscan_direct_throttle") {
+		t.Errorf("Unexpected vmstat summary %q\n", summary)
+	}
+}
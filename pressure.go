@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PressureResources are the resources pressure stall information is recorded for.
+var PressureResources = []string{"cpu", "io", "memory"}
+
+// pressureSource is a set of PSI files: the system wide files in /proc/pressure, or a cgroup's files.
+type pressureSource struct {
+	Name   string // "system" or "cgroup"
+	Prefix string // Path prefix of each resource's file, completed by the resource name and Suffix
+	Suffix string
+}
+
+// pressureLine holds one line of a PSI file. Some is the share of time at least one task stalled on
+// the resource, and full the share of time every task did.
+type pressureLine struct {
+	Avg10    float64
+	Avg60    float64
+	Avg300   float64
+	Kind     string // "some" or "full"
+	Resource string
+	Source   string
+	Total    int64 // Cumulative stall time in microseconds
+}
+
+type pressureSample struct {
+	Lines  []*pressureLine
+	Offset time.Duration // Time since the start of the run that the sample was taken
+}
+
+// pressureStats samples pressure stall information for the system, and for scriba's own cgroup when
+// the kernel exposes it.
+type pressureStats struct {
+	Samples []*pressureSample
+	Sources []*pressureSource
+}
+
+// PressureSummary is the peak pressure of one source, resource, and kind over the run, in percent.
+type PressureSummary struct {
+	Avg10Peak float64  `json:"avg10_peak"`
+	Avg60Peak float64  `json:"avg60_peak"`
+	Kind      string   `json:"kind"`        // some or full
+	Resource  string   `json:"resource"`    // cpu, io, or memory
+	Source    string   `json:"source"`      // system or cgroup
+	Stalled   *float64 `json:"stalled_pct"` // Share of the run tasks spent stalled, or null with a single sample
+}
+
+// newPressureStats returns a collector for every available PSI source, or nil when the kernel has none.
+func newPressureStats() *pressureStats {
+	s := &pressureStats{}
+
+	if _, err := os.Stat(path.Join(ProcRoot, "pressure", "io")); err == nil {
+		s.Sources = append(s.Sources, &pressureSource{Name: "system", Prefix: path.Join(ProcRoot, "pressure") + "/"})
+	}
+	if dir := cgroupDir(); dir != "" {
+		s.Sources = append(s.Sources, &pressureSource{Name: "cgroup", Prefix: dir + "/", Suffix: ".pressure"})
+	}
+
+	if len(s.Sources) == 0 {
+		return nil
+	}
+	return s
+}
+
+// cgroupDir returns the cgroup v2 directory of this process when it holds PSI files, or an empty string.
+func cgroupDir() string {
+	data, err := os.ReadFile(path.Join(ProcRoot, "self", "cgroup"))
+	if err != nil {
+		return ""
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		if !strings.HasPrefix(line, "0::") {
+			continue
+		}
+		group := strings.TrimPrefix(line, "0::")
+		if group == "/" {
+			// The root cgroup's pressure is the system's.
+			return ""
+		}
+		// The unified hierarchy is mounted at /sys/fs/cgroup, or below it on hybrid systems.
+		for _, mount := range []string{"fs/cgroup", "fs/cgroup/unified"} {
+			dir := path.Join(SysRoot, mount, group)
+			if _, err := os.Stat(path.Join(dir, "io.pressure")); err == nil {
+				return dir
+			}
+		}
+	}
+	return ""
+}
+
+// parsePressure parses the lines of a PSI file, such as "some avg10=0.12 avg60=0.05 avg300=0.01 total=1234".
+func parsePressure(data string) ([]*pressureLine, error) {
+	var lines []*pressureLine
+
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		line := &pressureLine{Kind: fields[0]}
+		for _, field := range fields[1:] {
+			key, value, ok := strings.Cut(field, "=")
+			if !ok {
+				return nil, fmt.Errorf("malformed pressure field %q", field)
+			}
+			var err error
+			switch key {
+			case "avg10":
+				line.Avg10, err = strconv.ParseFloat(value, 64)
+			case "avg60":
+				line.Avg60, err = strconv.ParseFloat(value, 64)
+			case "avg300":
+				line.Avg300, err = strconv.ParseFloat(value, 64)
+			case "total":
+				line.Total, err = strconv.ParseInt(value, 10, 64)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("malformed pressure field %q. %s", field, err)
+			}
+		}
+		lines = append(lines, line)
+	}
+	return lines, scanner.Err()
+}
+
+func (s *pressureStats) UpdateStats(start time.Time) error {
+	sample := &pressureSample{Offset: time.Now().Sub(start)}
+
+	for _, source := range s.Sources {
+		for _, resource := range PressureResources {
+			data, err := os.ReadFile(source.Prefix + resource + source.Suffix)
+			if os.IsNotExist(err) {
+				// The cpu file of a cgroup only exists when the cpu controller is enabled.
+				continue
+			} else if err != nil {
+				return err
+			}
+			lines, err := parsePressure(string(data))
+			if err != nil {
+				return err
+			}
+			for _, line := range lines {
+				line.Resource = resource
+				line.Source = source.Name
+			}
+			sample.Lines = append(sample.Lines, lines...)
+		}
+	}
+	s.Samples = append(s.Samples, sample)
+	return nil
+}
+
+func (s *pressureStats) Csv() string {
+	var output strings.Builder
+
+	output.WriteString("\"time ms\",\"source\",\"resource\",\"kind\",\"avg10\",\"avg60\",\"avg300\",\"total us\"\n")
+	for _, sample := range s.Samples {
+		for _, line := range sample.Lines {
+			fmt.Fprintf(
+				&output, "%d,\"%s\",\"%s\",\"%s\",%0.2f,%0.2f,%0.2f,%d\n",
+				sample.Offset.Milliseconds(), line.Source, line.Resource, line.Kind, line.Avg10, line.Avg60, line.Avg300, line.Total,
+			)
+		}
+	}
+	return output.String()
+}
+
+// Summarize returns the peak avg10 and avg60 of every source, resource, and kind over the run, and the
+// share of the run tasks spent stalled, from the change in total stall time. It returns nil without samples.
+func (s *pressureStats) Summarize() []*PressureSummary {
+	if len(s.Samples) == 0 {
+		return nil
+	}
+	first, last := s.Samples[0], s.Samples[len(s.Samples)-1]
+	elapsed := last.Offset - first.Offset
+
+	type peak struct {
+		first   int64
+		last    int64
+		summary *PressureSummary
+	}
+	var keys []string
+	peaks := make(map[string]*peak)
+	for _, sample := range s.Samples {
+		for _, line := range sample.Lines {
+			key := fmt.Sprintf("%s %s %s", line.Source, line.Resource, line.Kind)
+			p, ok := peaks[key]
+			if !ok {
+				p = &peak{first: line.Total, summary: &PressureSummary{Kind: line.Kind, Resource: line.Resource, Source: line.Source}}
+				peaks[key] = p
+				keys = append(keys, key)
+			}
+			if line.Avg10 > p.summary.Avg10Peak {
+				p.summary.Avg10Peak = line.Avg10
+			}
+			if line.Avg60 > p.summary.Avg60Peak {
+				p.summary.Avg60Peak = line.Avg60
+			}
+			p.last = line.Total
+		}
+	}
+
+	summary := []*PressureSummary{}
+	for _, key := range keys {
+		p := peaks[key]
+		if elapsed > 0 {
+			stalled := 100 * float64(p.last-p.first) / float64(elapsed.Microseconds())
+			p.summary.Stalled = &stalled
+		}
+		summary = append(summary, p.summary)
+	}
+	return summary
+}
+
+func (s *pressureStats) Summary() string {
+	summary := s.Summarize()
+	if summary == nil {
+		return "Pressure: Not enough samples\n"
+	}
+
+	output := "Pressure:\n"
+	for _, p := range summary {
+		output += fmt.Sprintf("  %s %s %s: peak avg10 %0.2f%%, peak avg60 %0.2f%%", p.Source, p.Resource, p.Kind, p.Avg10Peak, p.Avg60Peak)
+		if p.Stalled != nil {
+			output += fmt.Sprintf(", stalled %0.2f%% of the run", *p.Stalled)
+		}
+		output += "\n"
+	}
+	return output
+}
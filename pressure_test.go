@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPressureStats(t *testing.T) {
+	fakeRoot(t, map[string]string{
+		"proc/pressure/cpu":                      "some avg10=1.00 avg60=0.50 avg300=0.10 total=1000\nfull avg10=0.00 avg60=0.00 avg300=0.00 total=0\n",
+		"proc/pressure/io":                       "some avg10=20.00 avg60=5.00 avg300=1.00 total=100000\nfull avg10=10.00 avg60=2.50 avg300=0.50 total=50000\n",
+		"proc/pressure/memory":                   "some avg10=0.00 avg60=0.00 avg300=0.00 total=0\nfull avg10=0.00 avg60=0.00 avg300=0.00 total=0\n",
+		"proc/self/cgroup":                       "1:cpuset:/\n0::/scriba.scope\n",
+		"sys/fs/cgroup/scriba.scope/io.pressure": "some avg10=30.00 avg60=6.00 avg300=1.00 total=200000\nfull avg10=0.00 avg60=0.00 avg300=0.00 total=0\n",
+	})
+	start := time.Now()
+
+	s := newPressureStats()
+	if s == nil || len(s.Sources) != 2 || s.Sources[1].Name != "cgroup" {
+		t.Fatalf("Expected system and cgroup pressure sources, found %+v\n", s)
+	}
+	if err := s.UpdateStats(start); err != nil {
+		t.Fatalf("Unable to read pressure stats. %s\n", err)
+	}
+	// The cgroup has no cpu or memory pressure files, which are skipped.
+	if lines := s.Samples[0].Lines; len(lines) != 8 || lines[2].Resource != "io" || lines[2].Avg10 != 20 || lines[6].Source != "cgroup" {
+		t.Errorf("Unexpected pressure sample %+v\n", lines)
+	}
+
+	s.Samples = append(s.Samples, &pressureSample{
+		Offset: time.Second,
+		Lines:  []*pressureLine{{Avg10: 40, Avg60: 8, Kind: "some", Resource: "io", Source: "system", Total: 350000}},
+	})
+	summary := s.Summary()
+	if !strings.Contains(summary, "system io some: peak avg10 40.00%, peak avg60 8.00%, stalled 25.00% of the run") {
+		t.Errorf("Unexpected pressure summary %q\n", summary)
+	}
+	if summary := s.Summarize(); len(summary) != 8 || summary[2].Avg10Peak != 40 || summary[2].Stalled == nil || fmt.Sprintf("%0.2f", *summary[2].Stalled) != "25.00" {
+		t.Errorf("Unexpected pressure summary %+v\n", summary)
+	}
+	if csv := s.Csv(); !strings.Contains(csv, "\n0,\"cgroup\",\"io\",\"some\",30.00,6.00,1.00,200000\n") {
+		t.Errorf("Unexpected pressure CSV %q\n", csv)
+	}
+
+	if _, err := parsePressure("some avg10"); err == nil {
+		t.Errorf("A field without a value should be invalid.\n")
+	}
+}
+
+func TestPressureRootCgroup(t *testing.T) {
+	fakeRoot(t, map[string]string{"proc/self/cgroup": "0::/\n"})
+	if s := newPressureStats(); s != nil {
+		t.Errorf("Expected no pressure sources, found %+v\n", s.Sources)
+	}
+}
@@ -0,0 +1,472 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Roots of the proc and sysfs trees that statistics are read from, which tests point at fake trees.
+var (
+	ProcRoot = "/proc"
+	SysRoot  = "/sys"
+)
+
+// MemInfoFields are the /proc/meminfo fields recorded in each memory sample.
+var MemInfoFields = []string{
+	"MemTotal", "MemFree", "MemAvailable", "Buffers", "Cached", "SwapCached", "Active", "Inactive",
+	"Dirty", "Writeback", "AnonPages", "Mapped", "Shmem", "Slab", "SwapTotal", "SwapFree",
+}
+
+// cpuTimes holds the cumulative time a CPU, or every CPU for the "cpu" line, spent in each state, in clock ticks.
+type cpuTimes struct {
+	Idle    int64
+	IOWait  int64
+	IRQ     int64
+	Name    string
+	Nice    int64
+	SoftIRQ int64
+	Steal   int64
+	System  int64
+	User    int64
+}
+
+type cpuSample struct {
+	CPUs   []*cpuTimes
+	Offset time.Duration // Time since the start of the run that the sample was taken
+}
+
+type cpuStats struct {
+	Samples []*cpuSample
+}
+
+type memorySample struct {
+	Offset time.Duration
+	Values map[string]int64 // Bytes, keyed by /proc/meminfo field
+}
+
+type memoryStats struct {
+	Samples []*memorySample
+}
+
+// interruptLine holds the per-CPU counts of one line of /proc/interrupts.
+type interruptLine struct {
+	Counts []int64
+	IRQ    string
+	Name   string
+}
+
+type interruptSample struct {
+	Lines  []*interruptLine
+	Offset time.Duration
+}
+
+// interruptStats samples /proc/interrupts, keeping only the IRQs of the tested devices when they can be
+// identified, or every IRQ when none can.
+type interruptStats struct {
+	Names   []string // Device and controller names matched against IRQ names
+	Samples []*interruptSample
+	counts  int // Per-CPU counts held by Samples
+}
+
+// CPUSummary is the share of CPU time spent in each state over the run, in percent, and the busiest
+// single CPU over any one sampling interval.
+type CPUSummary struct {
+	Busiest     string  `json:"busiest_cpu"` // Empty when no single CPU was busy
+	BusiestBusy float64 `json:"busiest_cpu_pct"`
+	Idle        float64 `json:"idle_pct"`
+	IOWait      float64 `json:"iowait_pct"`
+	IRQ         float64 `json:"irq_pct"`
+	SoftIRQ     float64 `json:"softirq_pct"`
+	System      float64 `json:"system_pct"`
+	User        float64 `json:"user_pct"` // Includes nice time
+}
+
+// InterruptSummary is the rate of one IRQ over the run, and the CPU which handled the most of them.
+type InterruptSummary struct {
+	CPU      int     `json:"cpu"`
+	CPUShare float64 `json:"cpu_pct"` // Percentage of the IRQ's interrupts handled by CPU
+	IRQ      string  `json:"irq"`
+	Name     string  `json:"name"`
+	Rate     float64 `json:"per_sec"`
+}
+
+func (t *cpuTimes) total() int64 {
+	return t.User + t.Nice + t.System + t.Idle + t.IOWait + t.IRQ + t.SoftIRQ + t.Steal
+}
+
+// readCPUTimes parses the cpu lines of /proc/stat.
+func readCPUTimes() ([]*cpuTimes, error) {
+	var cpus []*cpuTimes
+
+	statFile, err := os.Open(path.Join(ProcRoot, "stat"))
+	if err != nil {
+		return nil, err
+	}
+	defer statFile.Close()
+
+	scanner := bufio.NewScanner(statFile)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 9 || !strings.HasPrefix(fields[0], "cpu") {
+			continue
+		}
+
+		t := &cpuTimes{Name: fields[0]}
+		for i, value := range []*int64{&t.User, &t.Nice, &t.System, &t.Idle, &t.IOWait, &t.IRQ, &t.SoftIRQ, &t.Steal} {
+			if *value, err = strconv.ParseInt(fields[i+1], 10, 64); err != nil {
+				return nil, fmt.Errorf("malformed %s line. %s", fields[0], err)
+			}
+		}
+		cpus = append(cpus, t)
+	}
+	return cpus, scanner.Err()
+}
+
+func (s *cpuStats) UpdateStats(start time.Time) error {
+	cpus, err := readCPUTimes()
+	if err != nil {
+		return err
+	}
+	s.Samples = append(s.Samples, &cpuSample{CPUs: cpus, Offset: time.Now().Sub(start)})
+	return nil
+}
+
+func (s *cpuStats) Csv() string {
+	var output strings.Builder
+
+	output.WriteString("\"time ms\",\"cpu\",\"user\",\"nice\",\"system\",\"idle\",\"iowait\",\"irq\",\"softirq\",\"steal\"\n")
+	for _, sample := range s.Samples {
+		for _, t := range sample.CPUs {
+			fmt.Fprintf(
+				&output, "%d,\"%s\",%d,%d,%d,%d,%d,%d,%d,%d\n",
+				sample.Offset.Milliseconds(), t.Name, t.User, t.Nice, t.System, t.Idle, t.IOWait, t.IRQ, t.SoftIRQ, t.Steal,
+			)
+		}
+	}
+	return output.String()
+}
+
+// Summarize returns the share of CPU time spent in each state between the first and last samples, and the
+// busiest single CPU over any one sampling interval, which reveals work pinned to one CPU. It returns nil
+// without two samples covering some time.
+func (s *cpuStats) Summarize() *CPUSummary {
+	if len(s.Samples) < 2 {
+		return nil
+	}
+
+	first, last := s.Samples[0].CPUs, s.Samples[len(s.Samples)-1].CPUs
+	if len(first) == 0 || len(last) == 0 {
+		return nil
+	}
+	all := cpuDelta(first[0], last[0])
+	ticks := float64(all.total())
+	if ticks == 0 {
+		return nil
+	}
+	summary := &CPUSummary{
+		Idle:    100 * float64(all.Idle) / ticks,
+		IOWait:  100 * float64(all.IOWait) / ticks,
+		IRQ:     100 * float64(all.IRQ) / ticks,
+		SoftIRQ: 100 * float64(all.SoftIRQ) / ticks,
+		System:  100 * float64(all.System) / ticks,
+		User:    100 * float64(all.User+all.Nice) / ticks,
+	}
+
+	for i := 1; i < len(s.Samples); i++ {
+		previous := make(map[string]*cpuTimes)
+		for _, t := range s.Samples[i-1].CPUs {
+			previous[t.Name] = t
+		}
+		for _, t := range s.Samples[i].CPUs {
+			p, ok := previous[t.Name]
+			if !ok || t.Name == "cpu" {
+				continue
+			}
+			d := cpuDelta(p, t)
+			if total := d.total(); total > 0 {
+				if b := 100 * float64(total-d.Idle-d.IOWait) / float64(total); b > summary.BusiestBusy {
+					summary.Busiest, summary.BusiestBusy = t.Name, b
+				}
+			}
+		}
+	}
+	return summary
+}
+
+func (s *cpuStats) Summary() string {
+	summary := s.Summarize()
+	if summary == nil {
+		return "CPU: Not enough samples\n"
+	}
+
+	output := fmt.Sprintf(
+		"CPU: user %0.1f%%, system %0.1f%%, iowait %0.1f%%, irq %0.1f%%, softirq %0.1f%%, idle %0.1f%%\n",
+		summary.User, summary.System, summary.IOWait, summary.IRQ, summary.SoftIRQ, summary.Idle,
+	)
+	if summary.Busiest != "" {
+		output += fmt.Sprintf("  Busiest CPU: %s at %0.1f%% busy\n", summary.Busiest, summary.BusiestBusy)
+	}
+	return output
+}
+
+func cpuDelta(previous *cpuTimes, current *cpuTimes) *cpuTimes {
+	return &cpuTimes{
+		Idle:    current.Idle - previous.Idle,
+		IOWait:  current.IOWait - previous.IOWait,
+		IRQ:     current.IRQ - previous.IRQ,
+		Name:    current.Name,
+		Nice:    current.Nice - previous.Nice,
+		SoftIRQ: current.SoftIRQ - previous.SoftIRQ,
+		Steal:   current.Steal - previous.Steal,
+		System:  current.System - previous.System,
+		User:    current.User - previous.User,
+	}
+}
+
+// readMemInfo parses /proc/meminfo, returning every field in bytes, or as a count for fields without a unit.
+func readMemInfo() (map[string]int64, error) {
+	values := make(map[string]int64)
+
+	memFile, err := os.Open(path.Join(ProcRoot, "meminfo"))
+	if err != nil {
+		return nil, err
+	}
+	defer memFile.Close()
+
+	scanner := bufio.NewScanner(memFile)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		value, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		if len(fields) > 2 && fields[2] == "kB" {
+			value *= KiB
+		}
+		values[strings.TrimSuffix(fields[0], ":")] = value
+	}
+	return values, scanner.Err()
+}
+
+func (s *memoryStats) UpdateStats(start time.Time) error {
+	values, err := readMemInfo()
+	if err != nil {
+		return err
+	}
+	s.Samples = append(s.Samples, &memorySample{Offset: time.Now().Sub(start), Values: values})
+	return nil
+}
+
+func (s *memoryStats) Csv() string {
+	var output strings.Builder
+
+	output.WriteString("\"time ms\"")
+	for _, field := range MemInfoFields {
+		fmt.Fprintf(&output, ",\"%s\"", field)
+	}
+	output.WriteString("\n")
+
+	for _, sample := range s.Samples {
+		fmt.Fprintf(&output, "%d", sample.Offset.Milliseconds())
+		for _, field := range MemInfoFields {
+			fmt.Fprintf(&output, ",%d", sample.Values[field])
+		}
+		output.WriteString("\n")
+	}
+	return output.String()
+}
+
+// deviceIRQNames returns the names which identify a device's interrupts: the device itself, and the
+// device's parent in sysfs, such as nvme0 for nvme0n1 or virtio1 for vda.
+func deviceIRQNames(device string) []string {
+	names := []string{device}
+	if target, err := os.Readlink(path.Join(SysRoot, "block", device, "device")); err == nil {
+		names = append(names, filepath.Base(target))
+	}
+	return names
+}
+
+// matchIRQName reports whether an IRQ name such as nvme0q3 belongs to one of names. A name must not be
+// followed by a digit, so nvme1 does not match nvme10q1.
+func matchIRQName(irqName string, names []string) bool {
+	for _, name := range names {
+		if strings.HasPrefix(irqName, name) {
+			rest := irqName[len(name):]
+			if rest == "" || rest[0] < '0' || rest[0] > '9' {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// readInterrupts parses /proc/interrupts.
+func readInterrupts() ([]*interruptLine, error) {
+	var lines []*interruptLine
+
+	irqFile, err := os.Open(path.Join(ProcRoot, "interrupts"))
+	if err != nil {
+		return nil, err
+	}
+	defer irqFile.Close()
+
+	scanner := bufio.NewScanner(irqFile)
+	if !scanner.Scan() {
+		return nil, scanner.Err()
+	}
+	cpus := len(strings.Fields(scanner.Text()))
+
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || !strings.HasSuffix(fields[0], ":") {
+			continue
+		}
+
+		line := &interruptLine{IRQ: strings.TrimSuffix(fields[0], ":")}
+		for i := 1; i <= cpus && i < len(fields); i++ {
+			count, err := strconv.ParseInt(fields[i], 10, 64)
+			if err != nil {
+				break
+			}
+			line.Counts = append(line.Counts, count)
+		}
+		if rest := fields[1+len(line.Counts):]; len(rest) > 0 {
+			line.Name = rest[len(rest)-1]
+		}
+		lines = append(lines, line)
+	}
+	return lines, scanner.Err()
+}
+
+func (s *interruptStats) UpdateStats(start time.Time) error {
+	lines, err := readInterrupts()
+	if err != nil {
+		return err
+	}
+
+	var matched []*interruptLine
+	for _, line := range lines {
+		if matchIRQName(line.Name, s.Names) {
+			matched = append(matched, line)
+		}
+	}
+	if len(matched) == 0 {
+		matched = lines
+	}
+	sample := &interruptSample{Lines: matched, Offset: time.Now().Sub(start)}
+	s.Samples = append(s.Samples, sample)
+	s.counts += sample.counts()
+	return nil
+}
+
+// counts returns the number of per-CPU counts in the sample.
+func (s *interruptSample) counts() int {
+	var counts int
+	for _, line := range s.Lines {
+		counts += len(line.Counts)
+	}
+	return counts
+}
+
+func (s *interruptStats) Csv() string {
+	var output strings.Builder
+
+	output.WriteString("\"time ms\",\"irq\",\"name\",\"cpu\",\"count\"\n")
+	for _, sample := range s.Samples {
+		for _, line := range sample.Lines {
+			for cpu, count := range line.Counts {
+				fmt.Fprintf(&output, "%d,\"%s\",\"%s\",%d,%d\n", sample.Offset.Milliseconds(), line.IRQ, line.Name, cpu, count)
+			}
+		}
+	}
+	return output.String()
+}
+
+// Summarize returns the interrupt rate of the 10 busiest recorded IRQs between the first and last
+// samples, and the share handled by the CPU which handled the most of them. It returns nil without two
+// samples covering some time.
+func (s *interruptStats) Summarize() []*InterruptSummary {
+	if len(s.Samples) < 2 {
+		return nil
+	}
+	first, last := s.Samples[0], s.Samples[len(s.Samples)-1]
+	seconds := (last.Offset - first.Offset).Seconds()
+	if seconds <= 0 {
+		return nil
+	}
+
+	previous := make(map[string]*interruptLine)
+	for _, line := range first.Lines {
+		previous[line.IRQ] = line
+	}
+
+	type irqRate struct {
+		cpu   int
+		line  *interruptLine
+		share float64
+		total int64
+	}
+	var rates []*irqRate
+	for _, line := range last.Lines {
+		p, ok := previous[line.IRQ]
+		if !ok || len(p.Counts) != len(line.Counts) {
+			continue
+		}
+		r := &irqRate{line: line}
+		var top int64
+		for cpu := range line.Counts {
+			d := line.Counts[cpu] - p.Counts[cpu]
+			r.total += d
+			if d > top {
+				r.cpu, top = cpu, d
+			}
+		}
+		if r.total > 0 {
+			r.share = 100 * float64(top) / float64(r.total)
+			rates = append(rates, r)
+		}
+	}
+	sort.Slice(rates, func(i, j int) bool { return rates[i].total > rates[j].total })
+
+	summary := []*InterruptSummary{}
+	for i, r := range rates {
+		if i == 10 {
+			break
+		}
+		summary = append(summary, &InterruptSummary{
+			CPU:      r.cpu,
+			CPUShare: r.share,
+			IRQ:      r.line.IRQ,
+			Name:     r.line.Name,
+			Rate:     float64(r.total) / seconds,
+		})
+	}
+	return summary
+}
+
+func (s *interruptStats) Summary() string {
+	summary := s.Summarize()
+	if summary == nil {
+		return "Interrupts: Not enough samples\n"
+	}
+
+	output := "Interrupts:\n"
+	for _, irq := range summary {
+		output += fmt.Sprintf("  %s (%s): %0.0f/sec, %0.1f%% on CPU %d\n", irq.IRQ, irq.Name, irq.Rate, irq.CPUShare, irq.CPU)
+	}
+	return output
+}
+
+func writeStatsFile(dir string, name string, content string) error {
+	return os.WriteFile(path.Join(dir, name), []byte(content), 0644)
+}
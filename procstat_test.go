@@ -0,0 +1,111 @@
+package main
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeRoot points ProcRoot and SysRoot at a temporary tree holding files, keyed by their path in the tree.
+func fakeRoot(t *testing.T, files map[string]string) string {
+	root := t.TempDir()
+	for name, content := range files {
+		if err := os.MkdirAll(path.Dir(path.Join(root, name)), 0755); err != nil {
+			t.Fatalf("Unable to create fake tree. %s\n", err)
+		}
+		if err := os.WriteFile(path.Join(root, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Unable to create fake tree. %s\n", err)
+		}
+	}
+
+	procRoot, sysRoot := ProcRoot, SysRoot
+	ProcRoot, SysRoot = path.Join(root, "proc"), path.Join(root, "sys")
+	t.Cleanup(func() { ProcRoot, SysRoot = procRoot, sysRoot })
+	return root
+}
+
+func TestCPUStats(t *testing.T) {
+	fakeRoot(t, map[string]string{"proc/stat": "cpu  100 0 100 800 0 0 0 0 0 0\ncpu0 50 0 50 400 0 0 0 0 0 0\ncpu1 50 0 50 400 0 0 0 0 0 0\nintr 5 1\n"})
+	start := time.Now()
+
+	s := &cpuStats{}
+	if err := s.UpdateStats(start); err != nil {
+		t.Fatalf("Unable to read CPU stats. %s\n", err)
+	}
+	if len(s.Samples[0].CPUs) != 3 || s.Samples[0].CPUs[1].Name != "cpu0" || s.Samples[0].CPUs[0].Idle != 800 {
+		t.Errorf("Unexpected CPU sample %+v\n", s.Samples[0].CPUs[0])
+	}
+
+	// cpu1 spends the whole interval in softirq, so it is the busiest CPU.
+	fakeRoot(t, map[string]string{"proc/stat": "cpu  100 0 100 900 100 0 100 0 0 0\ncpu0 50 0 50 500 100 0 0 0 0 0\ncpu1 50 0 50 400 0 0 100 0 0 0\n"})
+	if err := s.UpdateStats(start); err != nil {
+		t.Fatalf("Unable to read CPU stats. %s\n", err)
+	}
+	summary := s.Summary()
+	if !strings.Contains(summary, "iowait 33.3%, irq 0.0%, softirq 33.3%, idle 33.3%") || !strings.Contains(summary, "cpu1 at 100.0% busy") {
+		t.Errorf("Unexpected CPU summary %s\n", summary)
+	}
+	if summary := s.Summarize(); summary.Busiest != "cpu1" || summary.BusiestBusy != 100 || summary.User != 0 {
+		t.Errorf("Unexpected CPU summary %+v\n", summary)
+	}
+}
+
+func TestMemoryStats(t *testing.T) {
+	fakeRoot(t, map[string]string{"proc/meminfo": "MemTotal:       1000 kB\nDirty:             4 kB\nHugePages_Total:       2\n"})
+
+	values, err := readMemInfo()
+	if err != nil {
+		t.Fatalf("Unable to read memory stats. %s\n", err)
+	}
+	if values["MemTotal"] != 1000*KiB || values["Dirty"] != 4*KiB || values["HugePages_Total"] != 2 {
+		t.Errorf("Unexpected meminfo values %v\n", values)
+	}
+}
+
+func TestInterruptStats(t *testing.T) {
+	root := fakeRoot(t, map[string]string{
+		"proc/interrupts": "           CPU0       CPU1\n" +
+			" 40:         10          0  IR-PCI-MSI 1-edge      nvme0q0\n" +
+			" 41:        100        300  IR-PCI-MSI 2-edge      nvme0q1\n" +
+			" 42:          5          5  IR-PCI-MSI 3-edge      nvme10q1\n" +
+			"NMI:          0          0   Non-maskable interrupts\n",
+	})
+	if err := os.MkdirAll(path.Join(root, "devices", "nvme0"), 0755); err != nil {
+		t.Fatalf("Unable to create fake device. %s\n", err)
+	}
+	if err := os.MkdirAll(path.Join(root, "sys", "block", "nvme0n1"), 0755); err != nil {
+		t.Fatalf("Unable to create fake device. %s\n", err)
+	}
+	if err := os.Symlink(path.Join(root, "devices", "nvme0"), path.Join(root, "sys", "block", "nvme0n1", "device")); err != nil {
+		t.Fatalf("Unable to create fake device. %s\n", err)
+	}
+
+	start := time.Now()
+	s := &interruptStats{Names: deviceIRQNames("nvme0n1")}
+	if err := s.UpdateStats(start); err != nil {
+		t.Fatalf("Unable to read interrupts. %s\n", err)
+	}
+	if lines := s.Samples[0].Lines; len(lines) != 2 || lines[1].Name != "nvme0q1" || lines[1].Counts[1] != 300 {
+		t.Fatalf("Expected only nvme0 interrupts, found %+v\n", lines)
+	}
+
+	s.Samples = append(s.Samples, &interruptSample{Offset: 2 * time.Second, Lines: []*interruptLine{
+		{Counts: []int64{10, 0}, IRQ: "40", Name: "nvme0q0"},
+		{Counts: []int64{300, 1100}, IRQ: "41", Name: "nvme0q1"},
+	}})
+	s.Samples[0].Offset = 0
+	if summary := s.Summary(); !strings.Contains(summary, "41 (nvme0q1): 500/sec, 80.0% on CPU 1") || strings.Contains(summary, "nvme0q0") {
+		t.Errorf("Unexpected interrupt summary %s\n", summary)
+	}
+	if summary := s.Summarize(); len(summary) != 1 || summary[0].IRQ != "41" || summary[0].Rate != 500 || summary[0].CPU != 1 {
+		t.Errorf("Unexpected interrupt summary %+v\n", summary)
+	}
+
+	// Every interrupt is kept when none can be attributed to the tested devices.
+	s = &interruptStats{Names: []string{"sda"}}
+	if err := s.UpdateStats(start); err != nil || len(s.Samples[0].Lines) != 4 {
+		t.Errorf("Expected every interrupt. %v\n", err)
+	}
+}
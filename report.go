@@ -0,0 +1,299 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// ReportSchemaVersion is incremented whenever a field of the JSON report is renamed, removed, or
+// changes meaning. Adding fields does not change the version.
+const ReportSchemaVersion = 1
+
+const (
+	OutputJSON = "json"
+	OutputText = "text"
+)
+
+// Report is the machine readable result of a run, written by -output json.
+type Report struct {
+	Consistency []*ReportConsistency `json:"consistency,omitempty"` // Read-your-writes results of every reader, when -consistency is given
+	CPU         *CPUSummary          `json:"cpu,omitempty"`         // CPU time by state, when -stats is given
+	DiskStats   []*ReportDiskStats   `json:"diskstats"`
+	Duration    float64              `json:"duration_s"`
+	Environment *Environment         `json:"environment"`
+	Interrupts  []*InterruptSummary  `json:"interrupts,omitempty"` // The busiest recorded IRQs, when -stats is given
+	PageCache   *PageCacheState      `json:"page_cache,omitempty"` // Dirty and writeback bytes when the writers finished
+	Parameters  ReportParameters     `json:"parameters"`
+	Pressure    []*PressureSummary   `json:"pressure,omitempty"`   // Peak pressure stall information, when -stats is given
+	ProcessIO   *ProcessIO           `json:"process_io,omitempty"` // IO accounting of the process during the run
+	Read        *ReportResults       `json:"read"`
+	Runtime     *RuntimeSummary      `json:"runtime,omitempty"` // Go runtime health, when -stats is given
+	Schema      int                  `json:"schema_version"`
+	Start       time.Time            `json:"start"`
+	Version     ReportVersion        `json:"version"`
+	Write       *ReportResults       `json:"write"`
+}
+
+type ReportVersion struct {
+	BuildDate string `json:"build_date"`
+	Tag       string `json:"tag"`
+	Version   string `json:"version"`
+}
+
+type ReportParameters struct {
+	BatchSize    int64     `json:"batch_size"`
+	BlockSize    int64     `json:"block_size"`
+	BufferSize   int       `json:"buffer_size"`
+	Compression  float64   `json:"compression"` // Target compression ratio, or 0 when not targeted
+	Consistency  bool      `json:"consistency"`
+	Dedupe       float64   `json:"dedupe"`
+	Direct       bool      `json:"direct"`
+	Files        int       `json:"files"`
+	FileSize     int64     `json:"file_size"`
+	Paths        []string  `json:"paths"`
+	Pattern      string    `json:"pattern"`
+	PatternFile  string    `json:"pattern_file"`
+	Percentiles  []float64 `json:"percentiles"`
+	Prefill      bool      `json:"prefill"`
+	ReadPattern  string    `json:"read_pattern"`
+	Readers      int       `json:"readers"`
+	Seconds      int       `json:"seconds"`
+	Seed         int64     `json:"seed"`
+	Total        int64     `json:"total"`
+	Unique       bool      `json:"unique"`
+	WritePattern string    `json:"write_pattern"`
+	Writers      int       `json:"writers"`
+}
+
+// ReportConsistency holds the read-your-writes results of a reader.
+type ReportConsistency struct {
+	Checked    int64    `json:"checked"`
+	File       string   `json:"file"`
+	ID         int      `json:"id"`
+	Missing    int64    `json:"missing"`
+	Racing     int64    `json:"racing"`
+	Stale      int64    `json:"stale"`
+	Torn       int64    `json:"torn"`
+	Violations []string `json:"violations"` // The first violations found
+}
+
+// ReportLatency summarizes a latency histogram in microseconds.
+type ReportLatency struct {
+	Max         int64               `json:"max_us"`
+	Mean        int64               `json:"mean_us"`
+	Min         int64               `json:"min_us"`
+	Percentiles []*ReportPercentile `json:"percentiles"`
+}
+
+type ReportPercentile struct {
+	Percentile float64 `json:"percentile"`
+	Value      int64   `json:"value_us"`
+}
+
+// ReportThroughput holds the results of a worker, or the combined results of a group of workers.
+// Group rates cover the wall-clock window from the first worker starting to the last one stopping.
+type ReportThroughput struct {
+	Bytes       int64          `json:"bytes"`
+	CPU         *ReportCPU     `json:"cpu,omitempty"`
+	Elapsed     float64        `json:"elapsed_s"`
+	IOPS        float64        `json:"iops"`
+	Latency     *ReportLatency `json:"latency"`
+	MiBps       float64        `json:"mib_per_sec"`
+	Ops         int64          `json:"ops"`
+	Skew        float64        `json:"skew_s"`
+	Skewed      bool           `json:"skewed"`
+	Start       time.Time      `json:"start"`
+	Stop        time.Time      `json:"stop"`
+	WorkerMiBps float64        `json:"worker_mib_per_sec"`
+}
+
+// ReportCPU is the CPU time and context switches of the workers' threads.
+type ReportCPU struct {
+	Involuntary int64   `json:"involuntary_switches"`
+	PerOp       float64 `json:"us_per_op"`
+	System      float64 `json:"system_s"`
+	User        float64 `json:"user_s"`
+	Voluntary   int64   `json:"voluntary_switches"`
+}
+
+type ReportWorker struct {
+	ReportThroughput
+	File string `json:"file"`
+	ID   int    `json:"id"`
+}
+
+// ReportGroup holds the combined results of every worker of a file, path, or device.
+type ReportGroup struct {
+	ReportThroughput
+	Name string `json:"name"`
+}
+
+// ReportResults holds the results of every reader or every writer, at each level of aggregation.
+type ReportResults struct {
+	Devices []*ReportGroup    `json:"devices"`
+	Files   []*ReportGroup    `json:"files"`
+	Paths   []*ReportGroup    `json:"paths"`
+	Total   *ReportThroughput `json:"total"`
+	Workers []*ReportWorker   `json:"workers"`
+}
+
+// ReportDiskStats summarizes the change in a device's sysfs statistics between its first and last samples,
+// with the iostat style metrics over that time and their peaks over any one sampling interval.
+type ReportDiskStats struct {
+	Average      *DiskMetrics        `json:"average"`
+	DiscardBytes int64               `json:"discard_bytes"`
+	DiscardIO    int64               `json:"discard_ios"`
+	Device       string              `json:"device"`
+	Duration     float64             `json:"duration_s"`
+	Info         *DeviceInfo         `json:"device_info,omitempty"` // Identity and queue settings of the device
+	IOTime       int64               `json:"io_time_ms"`
+	Peak         *DiskMetrics        `json:"peak"`
+	ReadBytes    int64               `json:"read_bytes"`
+	ReadIO       int64               `json:"read_ios"`
+	Samples      int                 `json:"samples"`
+	Temperature  *TemperatureSummary `json:"temperature,omitempty"` // Temperature sensor ranges and throttling
+	Utilization  float64             `json:"utilization_pct"`
+	WriteBytes   int64               `json:"write_bytes"`
+	WriteIO      int64               `json:"write_ios"`
+}
+
+func newReportVersion() ReportVersion {
+	return ReportVersion{
+		BuildDate: BuildDate,
+		Tag:       VersionTag,
+		Version:   fmt.Sprintf("%s.%s.%s", VersionMajor, VersionMinor, VersionPoint),
+	}
+}
+
+func newReportConsistency(rc *ReaderConfig) *ReportConsistency {
+	r := &ReportConsistency{
+		Checked:    rc.Violations.Checked,
+		File:       rc.ReaderPath,
+		ID:         rc.ID,
+		Missing:    rc.Violations.Missing,
+		Racing:     rc.Violations.Racing,
+		Stale:      rc.Violations.Stale,
+		Torn:       rc.Violations.Torn,
+		Violations: rc.Violations.Violations,
+	}
+	if r.Violations == nil {
+		r.Violations = []string{}
+	}
+	return r
+}
+
+func newReportThroughput(t *Throughput, percentiles []float64) ReportThroughput {
+	latency := &ReportLatency{
+		Max:  t.Max().Microseconds(),
+		Mean: t.Average().Microseconds(),
+		Min:  t.Min().Microseconds(),
+	}
+	for _, p := range percentiles {
+		latency.Percentiles = append(latency.Percentiles, &ReportPercentile{Percentile: p, Value: t.Percentile(p / 100).Microseconds()})
+	}
+	var cpu *ReportCPU
+	if t.Usage != nil {
+		cpu = &ReportCPU{
+			Involuntary: t.Usage.Involuntary,
+			PerOp:       t.Usage.PerOp(t.Ops()),
+			System:      t.Usage.System.Seconds(),
+			User:        t.Usage.User.Seconds(),
+			Voluntary:   t.Usage.Voluntary,
+		}
+	}
+	return ReportThroughput{
+		Bytes:       t.Bytes,
+		CPU:         cpu,
+		Elapsed:     t.Elapsed().Seconds(),
+		IOPS:        t.IOPS,
+		Latency:     latency,
+		MiBps:       t.Rate,
+		Ops:         t.Ops(),
+		Skew:        t.Skew().Seconds(),
+		Skewed:      t.Skewed(),
+		Start:       t.Start,
+		Stop:        t.Stop,
+		WorkerMiBps: t.WorkerRate,
+	}
+}
+
+// newReportResults flattens each level of a ThroughputTree into lists of workers, files, paths, and devices.
+func newReportResults(tree *ThroughputTree, percentiles []float64) *ReportResults {
+	r := &ReportResults{Devices: []*ReportGroup{}, Files: []*ReportGroup{}, Paths: []*ReportGroup{}, Workers: []*ReportWorker{}}
+
+	for _, device := range tree.Devices {
+		r.Devices = append(r.Devices, &ReportGroup{ReportThroughput: newReportThroughput(device.Total, percentiles), Name: device.Name})
+		for _, ioPath := range device.Groups {
+			r.Paths = append(r.Paths, &ReportGroup{ReportThroughput: newReportThroughput(ioPath.Total, percentiles), Name: ioPath.Name})
+			for _, file := range ioPath.Groups {
+				r.Files = append(r.Files, &ReportGroup{ReportThroughput: newReportThroughput(file.Total, percentiles), Name: file.Name})
+				for _, w := range file.Workers {
+					r.Workers = append(r.Workers, &ReportWorker{ReportThroughput: newReportThroughput(w, percentiles), File: file.Name, ID: w.ID})
+				}
+			}
+		}
+	}
+	total := newReportThroughput(tree.Total, percentiles)
+	r.Total = &total
+
+	return r
+}
+
+func newReportDiskStats(d *diskStats) *ReportDiskStats {
+	summary := d.Summary()
+	r := &ReportDiskStats{
+		Average:     summary.Average,
+		Device:      d.Device,
+		Info:        d.Info,
+		Peak:        summary.Peak,
+		Samples:     len(d.Stats),
+		Temperature: d.TemperatureSummary(),
+	}
+	if len(d.Stats) < 2 {
+		return r
+	}
+
+	first, last := d.Stats[0], d.Stats[len(d.Stats)-1]
+	r.DiscardBytes = int64(last.DiscardSectors-first.DiscardSectors) * 512
+	r.DiscardIO = int64(last.DiscardIO - first.DiscardIO)
+	r.Duration = last.Time.Sub(first.Time).Seconds()
+	r.IOTime = int64(last.IOTime - first.IOTime)
+	r.ReadBytes = int64(last.ReadSectors-first.ReadSectors) * 512
+	r.ReadIO = int64(last.ReadIO - first.ReadIO)
+	r.WriteBytes = int64(last.WriteSectors-first.WriteSectors) * 512
+	r.WriteIO = int64(last.WriteIO - first.WriteIO)
+	if r.Duration > 0 {
+		r.Utilization = float64(r.IOTime) / 10 / r.Duration
+	}
+	return r
+}
+
+// Encode writes the report as indented JSON to w.
+func (r *Report) Encode(w io.Writer) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// Save writes the report to reportPath, or to stdout when reportPath is empty or "-".
+func (r *Report) Save(reportPath string) error {
+	if reportPath == "" || reportPath == "-" {
+		return r.Encode(os.Stdout)
+	}
+
+	reportFile, err := os.OpenFile(reportPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if err := r.Encode(reportFile); err != nil {
+		_ = reportFile.Close()
+		return err
+	}
+	return reportFile.Close()
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestReportResults(t *testing.T) {
+	start := time.Now()
+	results := make(map[string][]*Throughput)
+	files := []string{"/a/scriba.0.data", "/a/scriba.1.data", "/b/scriba.0.data"}
+	for i, file := range files {
+		for id := 0; id < 2; id++ {
+			h := NewHistogram(HistogramPrecision)
+			h.Record(time.Duration(i+1) * time.Millisecond)
+			usage := &ThreadUsage{System: 3 * time.Millisecond, User: time.Millisecond, Voluntary: 2}
+			results[file] = append(results[file], newThroughput(id, 10*MiB, start, start.Add(time.Second), h, usage))
+		}
+	}
+	parents := map[string]string{files[0]: "/a", files[1]: "/a", files[2]: "/b"}
+	devices := map[string]string{"/a": "sda", "/b": "sda"}
+
+	tree, err := groupThroughput(results, files, parents, devices)
+	if err != nil {
+		t.Fatalf("Unable to group results. %s\n", err)
+	}
+	r := newReportResults(tree, []float64{50, 99.9})
+	if len(r.Workers) != 6 || len(r.Files) != 3 || len(r.Paths) != 2 || len(r.Devices) != 1 {
+		t.Fatalf("Unexpected result groups %d workers, %d files, %d paths, %d devices\n", len(r.Workers), len(r.Files), len(r.Paths), len(r.Devices))
+	}
+	if r.Paths[0].Name != "/a" || r.Paths[0].Bytes != 40*MiB || r.Paths[0].MiBps != 40 || r.Paths[0].Ops != 4 {
+		t.Errorf("Unexpected path result %+v\n", r.Paths[0])
+	}
+	if r.Devices[0].Name != "sda" || r.Total.MiBps != 60 || r.Total.Latency.Max != 3000 {
+		t.Errorf("Unexpected device or total results %+v %+v\n", r.Devices[0], r.Total)
+	}
+	if p := r.Total.Latency.Percentiles[1]; p.Percentile != 99.9 || p.Value != 3000 {
+		t.Errorf("Unexpected total percentile %+v\n", p)
+	}
+	if cpu := r.Total.CPU; cpu == nil || cpu.PerOp != 4000 || cpu.System != 0.018 || cpu.Voluntary != 12 {
+		t.Errorf("Unexpected total CPU usage %+v\n", cpu)
+	}
+}
+
+func TestReportDiskStats(t *testing.T) {
+	start := time.Now()
+	d := &diskStats{Device: "sda", Stats: []*sysfsDiskStats{
+		{IOTime: 100, ReadIO: 10, ReadSectors: 80, Time: start, WriteIO: 5, WriteSectors: 40},
+		{IOTime: 600, ReadIO: 30, ReadSectors: 240, Time: start.Add(time.Second), WriteIO: 15, WriteSectors: 1064},
+	}}
+
+	r := newReportDiskStats(d)
+	if r.Samples != 2 || r.Duration != 1 || r.ReadIO != 20 || r.ReadBytes != 160*512 || r.WriteBytes != 1024*512 || r.Utilization != 50 {
+		t.Errorf("Unexpected disk stats summary %+v\n", r)
+	}
+	if r := newReportDiskStats(&diskStats{Device: "sdb"}); r.Samples != 0 || r.Duration != 0 {
+		t.Errorf("Unexpected summary without samples %+v\n", r)
+	}
+}
+
+func TestReportSchema(t *testing.T) {
+	var buf bytes.Buffer
+	var decoded map[string]interface{}
+
+	tree, _ := groupThroughput(map[string][]*Throughput{}, nil, nil, nil)
+	read := newReportResults(tree, []float64{50})
+	report := &Report{DiskStats: []*ReportDiskStats{}, Read: read, Schema: ReportSchemaVersion, Version: newReportVersion(), Write: read}
+	if err := report.Encode(&buf); err != nil {
+		t.Fatalf("Unable to encode report. %s\n", err)
+	}
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("Unable to decode report. %s\n", err)
+	}
+
+	// These fields are documented in the README, and CI jobs depend on them.
+	for _, key := range []string{"diskstats", "duration_s", "parameters", "read", "schema_version", "start", "version", "write"} {
+		if _, ok := decoded[key]; !ok {
+			t.Errorf("Report is missing %s\n", key)
+		}
+	}
+	if decoded["schema_version"] != float64(1) {
+		t.Errorf("Unexpected schema version %v\n", decoded["schema_version"])
+	}
+	write := decoded["write"].(map[string]interface{})
+	for _, key := range []string{"devices", "files", "paths", "total", "workers"} {
+		if _, ok := write[key]; !ok {
+			t.Errorf("Report results are missing %s\n", key)
+		}
+	}
+	total := write["total"].(map[string]interface{})
+	for _, key := range []string{"bytes", "iops", "latency", "mib_per_sec", "ops"} {
+		if _, ok := total[key]; !ok {
+			t.Errorf("Report throughput is missing %s\n", key)
+		}
+	}
+}
+
+func TestReportConsistency(t *testing.T) {
+	rc := &ReaderConfig{ID: 2, ReaderPath: "data"}
+	if r := newReportConsistency(rc); r.Violations == nil || r.File != "data" || r.ID != 2 {
+		t.Errorf("Unexpected consistency report %+v\n", r)
+	}
+
+	rc.Violations.Checked = 3
+	rc.Violations.Stale = 1
+	rc.Violations.Violations = []string{"data@0: stale"}
+	if r := newReportConsistency(rc); r.Checked != 3 || r.Stale != 1 || len(r.Violations) != 1 {
+		t.Errorf("Unexpected consistency report %+v\n", r)
+	}
+}
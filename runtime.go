@@ -0,0 +1,344 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"runtime/debug"
+	"runtime/metrics"
+	"sort"
+	"strings"
+	"time"
+)
+
+const OutlierCount = 10 // Slowest operations each worker keeps to compare with GC pauses
+
+const (
+	metricGCCycles       = "/gc/cycles/total:gc-cycles"
+	metricGCPauses       = "/gc/pauses:seconds"
+	metricGoroutines     = "/sched/goroutines:goroutines"
+	metricHeapGoal       = "/gc/heap/goal:bytes"
+	metricHeapObjects    = "/memory/classes/heap/objects:bytes"
+	metricSchedLatencies = "/sched/latencies:seconds"
+)
+
+// LatencyOutlier is one of the slowest operations of a worker.
+type LatencyOutlier struct {
+	ID      int
+	Latency time.Duration
+	Op      string
+	Start   time.Time
+}
+
+// LatencyOutliers keeps a worker's slowest operations. It is not safe for concurrent use.
+type LatencyOutliers struct {
+	ID       int
+	Op       string
+	Outliers []*LatencyOutlier // Sorted from slowest to fastest
+}
+
+func NewLatencyOutliers(op string, id int) *LatencyOutliers {
+	return &LatencyOutliers{ID: id, Op: op}
+}
+
+// Record keeps an operation which started at start if it is one of the OutlierCount slowest so far.
+func (o *LatencyOutliers) Record(start time.Time, latency time.Duration) {
+	if len(o.Outliers) == OutlierCount && latency <= o.Outliers[OutlierCount-1].Latency {
+		return
+	}
+	i := sort.Search(len(o.Outliers), func(i int) bool { return o.Outliers[i].Latency < latency })
+	outlier := &LatencyOutlier{ID: o.ID, Latency: latency, Op: o.Op, Start: start}
+	o.Outliers = append(o.Outliers[:i], append([]*LatencyOutlier{outlier}, o.Outliers[i:]...)...)
+	if len(o.Outliers) > OutlierCount {
+		o.Outliers = o.Outliers[:OutlierCount]
+	}
+}
+
+// worstOutliers returns the OutlierCount slowest operations of every worker.
+func worstOutliers(sets ...*LatencyOutliers) []*LatencyOutlier {
+	var outliers []*LatencyOutlier
+	for _, set := range sets {
+		if set != nil {
+			outliers = append(outliers, set.Outliers...)
+		}
+	}
+	sort.SliceStable(outliers, func(i, j int) bool { return outliers[i].Latency > outliers[j].Latency })
+	if len(outliers) > OutlierCount {
+		outliers = outliers[:OutlierCount]
+	}
+	return outliers
+}
+
+// gcPause is a stop-the-world pause of the garbage collector.
+type gcPause struct {
+	End   time.Time
+	Pause time.Duration
+}
+
+// overlaps reports whether the pause happened while an operation was in flight.
+func (p *gcPause) overlaps(o *LatencyOutlier) bool {
+	return p.End.Add(-p.Pause).Before(o.Start.Add(o.Latency)) && o.Start.Before(p.End)
+}
+
+type runtimeSample struct {
+	GCCycles       uint64
+	GCPauses       *metrics.Float64Histogram // Cumulative distribution of GC pause durations
+	Goroutines     uint64
+	HeapGoal       uint64
+	HeapObjects    uint64
+	Offset         time.Duration
+	SchedLatencies *metrics.Float64Histogram // Cumulative distribution of time goroutines waited to run
+}
+
+// runtimeStats samples the Go runtime's own health, so latency spikes caused by scriba's garbage
+// collection or scheduling can be told apart from the devices'.
+type runtimeStats struct {
+	Outliers   []*LatencyOutlier // The slowest operations of the run, set before Summary is called
+	Pauses     []*gcPause        // GC pauses during the run, thinned to the longest once MaxStatsSamples are kept
+	Samples    []*runtimeSample
+	lastPause  time.Time
+	pauseTotal time.Duration
+	start      time.Time
+}
+
+func (s *runtimeStats) UpdateStats(start time.Time) error {
+	if s.start.IsZero() {
+		// Only pauses during the run are of interest.
+		s.start, s.lastPause = start, start
+	}
+
+	samples := []metrics.Sample{
+		{Name: metricGCCycles}, {Name: metricGCPauses}, {Name: metricGoroutines},
+		{Name: metricHeapGoal}, {Name: metricHeapObjects}, {Name: metricSchedLatencies},
+	}
+	metrics.Read(samples)
+
+	sample := &runtimeSample{Offset: time.Now().Sub(start)}
+	for _, m := range samples {
+		switch m.Value.Kind() {
+		case metrics.KindUint64:
+			switch m.Name {
+			case metricGCCycles:
+				sample.GCCycles = m.Value.Uint64()
+			case metricGoroutines:
+				sample.Goroutines = m.Value.Uint64()
+			case metricHeapGoal:
+				sample.HeapGoal = m.Value.Uint64()
+			case metricHeapObjects:
+				sample.HeapObjects = m.Value.Uint64()
+			}
+		case metrics.KindFloat64Histogram:
+			switch m.Name {
+			case metricGCPauses:
+				sample.GCPauses = m.Value.Float64Histogram()
+			case metricSchedLatencies:
+				sample.SchedLatencies = m.Value.Float64Histogram()
+			}
+		}
+	}
+	s.Samples = append(s.Samples, sample)
+
+	// The runtime only keeps the times of recent pauses, so collect the new ones at every sample.
+	var gc debug.GCStats
+	debug.ReadGCStats(&gc)
+	var pauses []*gcPause
+	for i := 0; i < len(gc.PauseEnd) && i < len(gc.Pause); i++ {
+		if !gc.PauseEnd[i].After(s.lastPause) {
+			break
+		}
+		pauses = append(pauses, &gcPause{End: gc.PauseEnd[i], Pause: gc.Pause[i]})
+	}
+	for i := len(pauses) - 1; i >= 0; i-- {
+		if len(s.Pauses) >= MaxStatsSamples {
+			s.Pauses = thinPauses(s.Pauses)
+		}
+		s.Pauses = append(s.Pauses, pauses[i])
+		s.pauseTotal += pauses[i].Pause
+	}
+	if len(pauses) > 0 {
+		s.lastPause = pauses[0].End
+	}
+	return nil
+}
+
+// thinPauses keeps the longer pause of every adjacent pair, so the pauses most likely to explain slow
+// operations are kept in time order.
+func thinPauses(pauses []*gcPause) []*gcPause {
+	kept := pauses[:0]
+	for i := 0; i < len(pauses); i += 2 {
+		longest := pauses[i]
+		if i+1 < len(pauses) && pauses[i+1].Pause > longest.Pause {
+			longest = pauses[i+1]
+		}
+		kept = append(kept, longest)
+	}
+	// Release the dropped pauses.
+	for i := len(kept); i < len(pauses); i++ {
+		pauses[i] = nil
+	}
+	return kept
+}
+
+// histogramDelta returns the distribution of values recorded between two cumulative histograms.
+func histogramDelta(previous *metrics.Float64Histogram, current *metrics.Float64Histogram) *metrics.Float64Histogram {
+	if current == nil {
+		return nil
+	}
+	delta := &metrics.Float64Histogram{Buckets: current.Buckets, Counts: make([]uint64, len(current.Counts))}
+	for i, count := range current.Counts {
+		delta.Counts[i] = count
+		if previous != nil && i < len(previous.Counts) && count >= previous.Counts[i] {
+			delta.Counts[i] -= previous.Counts[i]
+		}
+	}
+	return delta
+}
+
+// histogramPercentile returns the upper bound of the bucket holding percentile p, from 0 to 1, of h.
+// Buckets without an upper bound report their lower bound.
+func histogramPercentile(h *metrics.Float64Histogram, p float64) time.Duration {
+	if h == nil {
+		return 0
+	}
+	var total uint64
+	for _, count := range h.Counts {
+		total += count
+	}
+	if total == 0 {
+		return 0
+	}
+
+	var seen uint64
+	for i, count := range h.Counts {
+		seen += count
+		if count > 0 && float64(seen) >= p*float64(total) {
+			bound := h.Buckets[i+1]
+			if math.IsInf(bound, 1) {
+				bound = h.Buckets[i]
+			}
+			return time.Duration(bound * float64(time.Second))
+		}
+	}
+	return 0
+}
+
+func (s *runtimeStats) Csv() string {
+	var output strings.Builder
+
+	output.WriteString("\"time ms\",\"heap bytes\",\"heap goal bytes\",\"goroutines\",\"gc cycles\",\"gc pause max us\",\"sched latency p99 us\",\"sched latency max us\"\n")
+	for i, sample := range s.Samples {
+		// The first sample has no interval before it.
+		previous := sample
+		if i > 0 {
+			previous = s.Samples[i-1]
+		}
+		schedLatencies := histogramDelta(previous.SchedLatencies, sample.SchedLatencies)
+		fmt.Fprintf(
+			&output, "%d,%d,%d,%d,%d,%d,%d,%d\n",
+			sample.Offset.Milliseconds(), sample.HeapObjects, sample.HeapGoal, sample.Goroutines, sample.GCCycles,
+			histogramPercentile(histogramDelta(previous.GCPauses, sample.GCPauses), 1).Microseconds(),
+			histogramPercentile(schedLatencies, 0.99).Microseconds(), histogramPercentile(schedLatencies, 1).Microseconds(),
+		)
+	}
+	return output.String()
+}
+
+// PausesCsv returns the time and duration of the GC pauses kept during the run.
+func (s *runtimeStats) PausesCsv() string {
+	var output strings.Builder
+
+	output.WriteString("\"time ms\",\"pause us\"\n")
+	for _, p := range s.Pauses {
+		fmt.Fprintf(&output, "%d,%d\n", p.End.Add(-p.Pause).Sub(s.start).Milliseconds(), p.Pause.Microseconds())
+	}
+	return output.String()
+}
+
+// RuntimeOutlier is one of the slowest operations of the run, and the longest GC pause while it was in flight.
+type RuntimeOutlier struct {
+	DuringGC bool   `json:"during_gc"` // A GC pause happened while the operation was in flight
+	GCPause  int64  `json:"gc_pause_us"`
+	ID       int    `json:"worker_id"`
+	Latency  int64  `json:"latency_us"`
+	Op       string `json:"op"`
+	Time     int64  `json:"time_ms"` // Time since the start of the run that the operation started
+}
+
+// RuntimeSummary is the health of the Go runtime over the run.
+type RuntimeSummary struct {
+	GCCycles        uint64            `json:"gc_cycles"`
+	GCPauseMax      int64             `json:"gc_pause_max_us"`
+	GCPauseTotal    int64             `json:"gc_pause_total_us"`
+	GCOutliers      int               `json:"gc_outliers"` // Outliers which were in flight during a GC pause
+	GoroutinesPeak  uint64            `json:"goroutines_peak"`
+	HeapPeak        uint64            `json:"heap_peak_bytes"`
+	Outliers        []*RuntimeOutlier `json:"outliers"`
+	SchedLatencyMax int64             `json:"sched_latency_max_us"`
+	SchedLatencyP99 int64             `json:"sched_latency_p99_us"`
+}
+
+// Summarize returns the peak heap and goroutines, and the GC pauses and scheduler latency over the run.
+// Each of the slowest operations is matched with the longest GC pause while it was in flight. It returns
+// nil with fewer than two samples.
+func (s *runtimeStats) Summarize() *RuntimeSummary {
+	if len(s.Samples) < 2 {
+		return nil
+	}
+	first, last := s.Samples[0], s.Samples[len(s.Samples)-1]
+	pauses := histogramDelta(first.GCPauses, last.GCPauses)
+	schedLatencies := histogramDelta(first.SchedLatencies, last.SchedLatencies)
+
+	summary := &RuntimeSummary{
+		GCCycles:        last.GCCycles - first.GCCycles,
+		GCPauseMax:      histogramPercentile(pauses, 1).Microseconds(),
+		Outliers:        []*RuntimeOutlier{},
+		SchedLatencyMax: histogramPercentile(schedLatencies, 1).Microseconds(),
+		SchedLatencyP99: histogramPercentile(schedLatencies, 0.99).Microseconds(),
+	}
+	for _, sample := range s.Samples {
+		if sample.HeapObjects > summary.HeapPeak {
+			summary.HeapPeak = sample.HeapObjects
+		}
+		if sample.Goroutines > summary.GoroutinesPeak {
+			summary.GoroutinesPeak = sample.Goroutines
+		}
+	}
+	summary.GCPauseTotal = s.pauseTotal.Microseconds()
+
+	for _, o := range s.Outliers {
+		outlier := &RuntimeOutlier{ID: o.ID, Latency: o.Latency.Microseconds(), Op: o.Op, Time: o.Start.Sub(s.start).Milliseconds()}
+		for _, p := range s.Pauses {
+			if p.overlaps(o) && (!outlier.DuringGC || p.Pause.Microseconds() > outlier.GCPause) {
+				outlier.DuringGC, outlier.GCPause = true, p.Pause.Microseconds()
+			}
+		}
+		if outlier.DuringGC {
+			summary.GCOutliers++
+		}
+		summary.Outliers = append(summary.Outliers, outlier)
+	}
+	return summary
+}
+
+// Summary returns the runtime summary of the run, warning when the slowest operations were in flight
+// during a GC pause.
+func (s *runtimeStats) Summary() string {
+	summary := s.Summarize()
+	if summary == nil {
+		return "Go runtime: Not enough samples\n"
+	}
+
+	output := fmt.Sprintf(
+		"Go runtime:\n  Peak heap: %s, peak goroutines: %d\n  GC: %d cycles, %d us paused, max pause %d us\n  Scheduler latency: P99 %d us, max %d us\n",
+		humanizeSize(float64(summary.HeapPeak), true), summary.GoroutinesPeak, summary.GCCycles, summary.GCPauseTotal,
+		summary.GCPauseMax, summary.SchedLatencyP99, summary.SchedLatencyMax,
+	)
+	if summary.GCOutliers > 0 {
+		output += fmt.Sprintf("  WARNING: %d of the %d slowest operations were in flight during a GC pause:\n", summary.GCOutliers, len(summary.Outliers))
+		for _, o := range summary.Outliers {
+			if o.DuringGC {
+				output += fmt.Sprintf("    %s [%d]: %d us at %d ms, GC pause %d us\n", o.Op, o.ID, o.Latency, o.Time, o.GCPause)
+			}
+		}
+	}
+	return output
+}
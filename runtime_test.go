@@ -0,0 +1,103 @@
+package main
+
+import (
+	"math"
+	"runtime"
+	"runtime/metrics"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLatencyOutliers(t *testing.T) {
+	start := time.Now()
+	o := NewLatencyOutliers(OpWrite, 3)
+	for i := 1; i <= 2*OutlierCount; i++ {
+		o.Record(start.Add(time.Duration(i)*time.Second), time.Duration(i%7)*time.Millisecond+time.Duration(i)*time.Microsecond)
+	}
+	if len(o.Outliers) != OutlierCount || o.Outliers[0].Latency != 6*time.Millisecond+20*time.Microsecond || o.Outliers[0].ID != 3 {
+		t.Fatalf("Unexpected slowest operation %+v\n", o.Outliers[0])
+	}
+	for i := 1; i < len(o.Outliers); i++ {
+		if o.Outliers[i].Latency > o.Outliers[i-1].Latency {
+			t.Errorf("Outliers are not sorted from slowest to fastest at %d\n", i)
+		}
+	}
+
+	other := NewLatencyOutliers(OpRead, 0)
+	other.Record(start, time.Second)
+	if worst := worstOutliers(o, other, nil); len(worst) != OutlierCount || worst[0].Op != OpRead || worst[1].Op != OpWrite {
+		t.Errorf("Unexpected worst outliers %+v\n", worst)
+	}
+}
+
+func TestRuntimeHistogramPercentile(t *testing.T) {
+	h := &metrics.Float64Histogram{Buckets: []float64{0, 0.001, 0.002, math.Inf(1)}, Counts: []uint64{98, 1, 1}}
+	if p := histogramPercentile(h, 0.5); p != time.Millisecond {
+		t.Errorf("Unexpected P50 %s\n", p)
+	}
+	if p := histogramPercentile(h, 0.99); p != 2*time.Millisecond {
+		t.Errorf("Unexpected P99 %s\n", p)
+	}
+	// The last bucket has no upper bound, so its lower bound is reported.
+	if p := histogramPercentile(h, 1); p != 2*time.Millisecond {
+		t.Errorf("Unexpected max %s\n", p)
+	}
+	previous := &metrics.Float64Histogram{Buckets: h.Buckets, Counts: []uint64{90, 1, 0}}
+	if delta := histogramDelta(previous, h); delta.Counts[0] != 8 || delta.Counts[1] != 0 || delta.Counts[2] != 1 {
+		t.Errorf("Unexpected histogram delta %v\n", delta.Counts)
+	}
+}
+
+func TestRuntimeStats(t *testing.T) {
+	start := time.Now()
+	s := &runtimeStats{}
+	if err := s.UpdateStats(start); err != nil {
+		t.Fatalf("Unable to read runtime stats. %s\n", err)
+	}
+	runtime.GC()
+	if err := s.UpdateStats(start); err != nil {
+		t.Fatalf("Unable to read runtime stats. %s\n", err)
+	}
+	if len(s.Pauses) == 0 || s.Samples[1].Goroutines == 0 || s.Samples[1].HeapObjects == 0 {
+		t.Fatalf("Expected GC pauses and runtime metrics, found %d pauses and %+v\n", len(s.Pauses), s.Samples[1])
+	}
+
+	pause := s.Pauses[len(s.Pauses)-1]
+	s.Outliers = []*LatencyOutlier{
+		{ID: 1, Latency: time.Second, Op: OpWrite, Start: pause.End.Add(-time.Millisecond)},
+		{ID: 2, Latency: time.Millisecond, Op: OpRead, Start: pause.End.Add(time.Second)},
+	}
+	summary := s.Summarize()
+	if summary.GCCycles == 0 || summary.GCOutliers != 1 || !summary.Outliers[0].DuringGC || summary.Outliers[1].DuringGC {
+		t.Errorf("Unexpected runtime summary %+v\n", summary)
+	}
+	if output := s.Summary(); !strings.Contains(output, "WARNING: 1 of the 2 slowest operations") || !strings.Contains(output, "write [1]: 1000000 us") {
+		t.Errorf("Unexpected runtime summary %q\n", output)
+	}
+	if lines := strings.Split(strings.TrimSpace(s.PausesCsv()), "\n"); len(lines) != len(s.Pauses)+1 {
+		t.Errorf("Unexpected GC pause CSV %q\n", lines)
+	}
+}
+
+func TestThinPauses(t *testing.T) {
+	start := time.Now()
+	s := &runtimeStats{start: start}
+	for i := 0; i < MaxStatsSamples; i++ {
+		s.Pauses = append(s.Pauses, &gcPause{End: start.Add(time.Duration(i) * time.Second), Pause: time.Microsecond})
+	}
+	s.Pauses[5].Pause = time.Millisecond
+
+	s.Pauses = thinPauses(s.Pauses)
+	if len(s.Pauses) != MaxStatsSamples/2 {
+		t.Fatalf("Expected %d pauses after thinning, found %d.\n", MaxStatsSamples/2, len(s.Pauses))
+	}
+	if p := s.Pauses[2]; p.Pause != time.Millisecond || !p.End.Equal(start.Add(5*time.Second)) {
+		t.Errorf("The longest pause was not kept. %+v\n", p)
+	}
+	for i := 1; i < len(s.Pauses); i++ {
+		if !s.Pauses[i].End.After(s.Pauses[i-1].End) {
+			t.Fatalf("Thinned pauses are out of order at %d.\n", i)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ThreadUsage is the CPU time and context switches of a worker's OS thread.
+type ThreadUsage struct {
+	Involuntary int64 // Context switches forced by the scheduler
+	System      time.Duration
+	User        time.Duration
+	Voluntary   int64 // Context switches from blocking, such as waiting for IO
+}
+
+// Sub returns the usage between an earlier sample and this one.
+func (u *ThreadUsage) Sub(earlier *ThreadUsage) *ThreadUsage {
+	return &ThreadUsage{
+		Involuntary: u.Involuntary - earlier.Involuntary,
+		System:      u.System - earlier.System,
+		User:        u.User - earlier.User,
+		Voluntary:   u.Voluntary - earlier.Voluntary,
+	}
+}
+
+// Add accumulates the usage of another thread.
+func (u *ThreadUsage) Add(other *ThreadUsage) {
+	u.Involuntary += other.Involuntary
+	u.System += other.System
+	u.User += other.User
+	u.Voluntary += other.Voluntary
+}
+
+// CPU returns the user and system time together.
+func (u *ThreadUsage) CPU() time.Duration {
+	return u.User + u.System
+}
+
+// PerOp returns the CPU time in microseconds spent on each of ops operations.
+func (u *ThreadUsage) PerOp(ops int64) float64 {
+	if ops <= 0 {
+		return 0
+	}
+	return float64(u.CPU().Microseconds()) / float64(ops)
+}
+
+// workerUsage returns the usage of the calling goroutine's thread, which must be locked to it with
+// runtime.LockOSThread, or nil when the platform does not provide it.
+func workerUsage() *ThreadUsage {
+	u, err := threadUsage()
+	if err != nil {
+		if Debug {
+			log.Printf("Unable to read thread usage. %s\n", err)
+		}
+		return nil
+	}
+	return u
+}
+
+// ProcessIO holds the IO accounting of /proc/self/io. The character counts are the bytes passed to read
+// and write calls, while the byte counts are the bytes the process caused to be read from or written to
+// the devices, so the difference is served or absorbed by the page cache.
+type ProcessIO struct {
+	CancelledWriteBytes int64 `json:"cancelled_write_bytes"` // Bytes dirtied then truncated or deleted before writeback
+	RChar               int64 `json:"rchar"`
+	ReadBytes           int64 `json:"read_bytes"`
+	WChar               int64 `json:"wchar"`
+	WriteBytes          int64 `json:"write_bytes"`
+}
+
+// readProcessIO parses /proc/self/io.
+func readProcessIO() (*ProcessIO, error) {
+	ioFile, err := os.Open(path.Join(ProcRoot, "self", "io"))
+	if err != nil {
+		return nil, err
+	}
+	defer ioFile.Close()
+
+	p := &ProcessIO{}
+	fields := map[string]*int64{
+		"cancelled_write_bytes": &p.CancelledWriteBytes,
+		"rchar":                 &p.RChar,
+		"read_bytes":            &p.ReadBytes,
+		"wchar":                 &p.WChar,
+		"write_bytes":           &p.WriteBytes,
+	}
+	scanner := bufio.NewScanner(ioFile)
+	for scanner.Scan() {
+		name, value, ok := strings.Cut(scanner.Text(), ":")
+		if field, known := fields[name]; ok && known {
+			if *field, err = strconv.ParseInt(strings.TrimSpace(value), 10, 64); err != nil {
+				return nil, fmt.Errorf("malformed process IO field %q. %s", name, err)
+			}
+		}
+	}
+	return p, scanner.Err()
+}
+
+// Sub returns the IO between an earlier sample and this one.
+func (p *ProcessIO) Sub(earlier *ProcessIO) *ProcessIO {
+	return &ProcessIO{
+		CancelledWriteBytes: p.CancelledWriteBytes - earlier.CancelledWriteBytes,
+		RChar:               p.RChar - earlier.RChar,
+		ReadBytes:           p.ReadBytes - earlier.ReadBytes,
+		WChar:               p.WChar - earlier.WChar,
+		WriteBytes:          p.WriteBytes - earlier.WriteBytes,
+	}
+}
+
+// deviceShare returns the percentage of requested bytes which went to the devices.
+func deviceShare(device int64, requested int64) float64 {
+	if requested <= 0 {
+		return 0
+	}
+	return 100 * float64(device) / float64(requested)
+}
+
+func (p *ProcessIO) String() string {
+	return fmt.Sprintf(
+		"Process IO:\n"+
+			"  Read: %s requested, %s from devices (%0.1f%%), %0.1f%% from cache\n"+
+			"  Written: %s requested, %s to devices (%0.1f%%), %s cancelled\n",
+		humanizeSize(float64(p.RChar), true), humanizeSize(float64(p.ReadBytes), true), deviceShare(p.ReadBytes, p.RChar),
+		100-deviceShare(p.ReadBytes, p.RChar),
+		humanizeSize(float64(p.WChar), true), humanizeSize(float64(p.WriteBytes), true), deviceShare(p.WriteBytes, p.WChar),
+		humanizeSize(float64(p.CancelledWriteBytes), true),
+	)
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// threadUsage is only supported on linux, where getrusage can report a single thread.
+func threadUsage() (*ThreadUsage, error) {
+	return nil, errors.New("thread resource usage is not supported on this platform")
+}
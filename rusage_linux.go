@@ -0,0 +1,20 @@
+package main
+
+import (
+	"syscall"
+	"time"
+)
+
+// threadUsage returns the resource usage of the calling thread.
+func threadUsage() (*ThreadUsage, error) {
+	var r syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_THREAD, &r); err != nil {
+		return nil, err
+	}
+	return &ThreadUsage{
+		Involuntary: int64(r.Nivcsw),
+		System:      time.Duration(r.Stime.Nano()),
+		User:        time.Duration(r.Utime.Nano()),
+		Voluntary:   int64(r.Nvcsw),
+	}, nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestThreadUsage(t *testing.T) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	start, err := threadUsage()
+	if err != nil {
+		t.Skipf("Thread usage is not available. %s\n", err)
+	}
+	for deadline := time.Now().Add(20 * time.Millisecond); time.Now().Before(deadline); {
+	}
+	stop, err := threadUsage()
+	if err != nil {
+		t.Fatalf("Unable to read thread usage. %s\n", err)
+	}
+	if u := stop.Sub(start); u.CPU() <= 0 {
+		t.Errorf("Expected the busy loop to use CPU time, found %+v\n", u)
+	}
+
+	u := &ThreadUsage{System: 3 * time.Millisecond, User: time.Millisecond}
+	if perOp := u.PerOp(100); perOp != 40 {
+		t.Errorf("Unexpected CPU time per op %0.1f\n", perOp)
+	}
+}
+
+func TestProcessIO(t *testing.T) {
+	fakeRoot(t, map[string]string{
+		"proc/self/io": "rchar: 1000\nwchar: 2000\nsyscr: 9\nsyscw: 4\nread_bytes: 0\nwrite_bytes: 0\ncancelled_write_bytes: 0\n",
+	})
+	start, err := readProcessIO()
+	if err != nil || start.RChar != 1000 || start.WChar != 2000 {
+		t.Fatalf("Unexpected process IO %+v. %v\n", start, err)
+	}
+
+	stop := &ProcessIO{RChar: 1000 + 4*MiB, ReadBytes: MiB, WChar: 2000 + 4*MiB, WriteBytes: 3 * MiB, CancelledWriteBytes: MiB}
+	output := stop.Sub(start).String()
+	for _, expected := range []string{
+		"Read: 4.00 MB requested, 1024.00 KB from devices (25.0%), 75.0% from cache",
+		"Written: 4.00 MB requested, 3.00 MB to devices (75.0%), 1024.00 KB cancelled",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("Expected %q in %q\n", expected, output)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	ScanError   = "error"
+	ScanRetried = "retried"
+	ScanSlow    = "slow"
+)
+
+// ScanConfig describes a full-surface sequential read scan of every target.
+type ScanConfig struct {
+	BlockSize  int64
+	Direct     bool
+	FileSize   int64
+	Files      []string
+	OutputPath string
+	RegionSize int64
+	Retries    int
+	Threshold  time.Duration
+}
+
+// scanRange is a contiguous range of chunks which were slow, needed retries, or could not be read.
+type scanRange struct {
+	End     int64
+	Kind    string
+	Latency time.Duration // The worst latency observed in the range
+	Start   int64
+}
+
+// scanRegion accumulates read latencies for one fixed size region of a target.
+type scanRegion struct {
+	Bytes   int64
+	Errors  int64
+	Max     time.Duration
+	Min     time.Duration
+	Offset  int64
+	Reads   int64
+	Retries int64
+	Total   time.Duration
+}
+
+type scanResult struct {
+	Bytes   int64
+	Path    string
+	Ranges  []*scanRange
+	Regions []*scanRegion
+	Time    time.Duration
+}
+
+// scanLabel converts a target path into a string usable in an output file name.
+func scanLabel(target string) string {
+	return strings.ReplaceAll(strings.Trim(target, "/"), "/", "_")
+}
+
+func (r *scanRegion) add(latency time.Duration, bytes int64) {
+	if r.Reads == 0 || latency < r.Min {
+		r.Min = latency
+	}
+	if latency > r.Max {
+		r.Max = latency
+	}
+	r.Reads++
+	r.Bytes += bytes
+	r.Total += latency
+}
+
+func (r *scanRegion) Average() time.Duration {
+	if r.Reads == 0 {
+		return 0
+	}
+	return r.Total / time.Duration(r.Reads)
+}
+
+func (r *scanRegion) Csv() string {
+	return fmt.Sprintf(
+		"%d,%d,%d,%d,%d,%d,%d,%d",
+		r.Offset, r.Bytes, r.Reads, r.Errors, r.Retries,
+		r.Min.Microseconds(), r.Average().Microseconds(), r.Max.Microseconds(),
+	)
+}
+
+// addRange extends the last range when it is contiguous with offset and of the same kind, otherwise a new range is started.
+func (s *scanResult) addRange(kind string, offset int64, length int64, latency time.Duration) {
+	if len(s.Ranges) > 0 {
+		last := s.Ranges[len(s.Ranges)-1]
+		if last.Kind == kind && last.End == offset {
+			last.End = offset + length
+			if latency > last.Latency {
+				last.Latency = latency
+			}
+			return
+		}
+	}
+	s.Ranges = append(s.Ranges, &scanRange{Start: offset, End: offset + length, Kind: kind, Latency: latency})
+}
+
+// scanTarget sequentially reads the whole target, timing every chunk and retrying chunks which fail to read.
+func scanTarget(target string, config *ScanConfig) *scanResult {
+	var region *scanRegion
+
+	result := &scanResult{Path: target}
+	data := make([]byte, config.BlockSize)
+	size := targetSize(target, config.FileSize)
+
+	workFile, err := os.OpenFile(target, readerFlags(config.Direct), 0644)
+	if err != nil {
+		log.Printf("[Scan] ERROR: Unable to open %s. %s\n", target, err)
+		result.addRange(ScanError, 0, size, 0)
+		return result
+	}
+	defer func(workFile *os.File) {
+		if err := workFile.Close(); err != nil {
+			log.Printf("[Scan] ERROR: Unable to close file %s. %s\n", workFile.Name(), err)
+		}
+	}(workFile)
+
+	startTime := time.Now()
+	for offset := int64(0); offset < size; offset += config.BlockSize {
+		var latency time.Duration
+		var n int
+		var readErr error
+
+		if Stop {
+			break
+		}
+		if region == nil || offset >= region.Offset+config.RegionSize {
+			region = &scanRegion{Offset: offset - offset%config.RegionSize}
+			result.Regions = append(result.Regions, region)
+		}
+
+		bytesNeeded := config.BlockSize
+		if size-offset < bytesNeeded {
+			bytesNeeded = size - offset
+		}
+
+		retries := 0
+		for attempt := 0; attempt <= config.Retries; attempt++ {
+			if attempt > 0 {
+				retries++
+			}
+			latencyStart := time.Now()
+			n, readErr = workFile.ReadAt(data[:bytesNeeded], offset)
+			latency = time.Now().Sub(latencyStart)
+			if readErr == nil || readErr == io.EOF {
+				break
+			}
+			if Verbose {
+				log.Printf("[Scan] %s: Read failed at offset %d, attempt %d. %s\n", target, offset, attempt+1, readErr)
+			}
+		}
+		region.Retries += int64(retries)
+
+		switch {
+		case readErr != nil && readErr != io.EOF:
+			region.Errors++
+			result.addRange(ScanError, offset, bytesNeeded, latency)
+			continue
+		case retries > 0:
+			result.addRange(ScanRetried, offset, bytesNeeded, latency)
+		case config.Threshold > 0 && latency > config.Threshold:
+			result.addRange(ScanSlow, offset, bytesNeeded, latency)
+		}
+		region.add(latency, int64(n))
+		result.Bytes += int64(n)
+	}
+	result.Time = time.Now().Sub(startTime)
+
+	return result
+}
+
+// scan reads every target in turn, so targets sharing a device do not distort each other's latency.
+func scan(config *ScanConfig) []*scanResult {
+	var results []*scanResult
+
+	for _, target := range config.Files {
+		if Stop {
+			break
+		}
+		log.Printf("Scanning %s\n", target)
+		results = append(results, scanTarget(target, config))
+	}
+
+	return results
+}
+
+func (s *scanResult) String() string {
+	var output string
+
+	output += fmt.Sprintf(
+		"  %s: %s in %0.2f sec. (%0.2f MiB/sec), %d flagged ranges\n",
+		s.Path, humanizeSize(float64(s.Bytes), true), s.Time.Seconds(),
+		float64(s.Bytes)/MiB/s.Time.Seconds(), len(s.Ranges),
+	)
+	for _, item := range s.Ranges {
+		output += fmt.Sprintf("    %-7s %d-%d (%s), worst %d us\n", item.Kind, item.Start, item.End, humanizeSize(float64(item.End-item.Start), true), item.Latency.Microseconds())
+	}
+	return output
+}
+
+// Write saves the per-region latency CSV and the flagged range CSV for the scanned target.
+func (s *scanResult) Write(dir string) error {
+	regionFile, regionFileError := os.OpenFile(path.Join(dir, fmt.Sprintf("scan.%s.csv", scanLabel(s.Path))), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if regionFileError != nil {
+		return regionFileError
+	}
+
+	if _, err := regionFile.WriteString("\"offset\",\"bytes\",\"reads\",\"errors\",\"retries\",\"min us\",\"avg us\",\"max us\"\n"); err != nil {
+		log.Printf("ERROR: Unable to write to scan region file. %s\n", err)
+		return err
+	}
+	for _, item := range s.Regions {
+		if _, err := regionFile.WriteString(item.Csv() + "\n"); err != nil {
+			log.Printf("ERROR: Unable to write to scan region file. %s\n", err)
+			return err
+		}
+	}
+	_ = regionFile.Sync()
+	if closeErr := regionFile.Close(); closeErr != nil {
+		log.Printf("ERROR: Unable to close scan region file. %s\n", closeErr)
+		return closeErr
+	}
+
+	rangeFile, rangeFileError := os.OpenFile(path.Join(dir, fmt.Sprintf("scan.%s.ranges.csv", scanLabel(s.Path))), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if rangeFileError != nil {
+		return rangeFileError
+	}
+
+	if _, err := rangeFile.WriteString("\"start\",\"end\",\"kind\",\"worst latency us\"\n"); err != nil {
+		log.Printf("ERROR: Unable to write to scan range file. %s\n", err)
+		return err
+	}
+	for _, item := range s.Ranges {
+		if _, err := rangeFile.WriteString(fmt.Sprintf("%d,%d,\"%s\",%d\n", item.Start, item.End, item.Kind, item.Latency.Microseconds())); err != nil {
+			log.Printf("ERROR: Unable to write to scan range file. %s\n", err)
+			return err
+		}
+	}
+	_ = rangeFile.Sync()
+	if closeErr := rangeFile.Close(); closeErr != nil {
+		log.Printf("ERROR: Unable to close scan range file. %s\n", closeErr)
+		return closeErr
+	}
+
+	return nil
+}
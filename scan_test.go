@@ -0,0 +1,61 @@
+package main
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestScanTarget(t *testing.T) {
+	target := path.Join(t.TempDir(), "scan.data")
+	if err := os.WriteFile(target, make([]byte, 1024*1024), 0644); err != nil {
+		t.Fatalf("Unable to create scan target. %s\n", err)
+	}
+
+	config := &ScanConfig{BlockSize: 65536, FileSize: 1024 * 1024, RegionSize: 262144, Threshold: time.Hour}
+	result := scanTarget(target, config)
+	if result.Bytes != 1024*1024 {
+		t.Errorf("Expected to scan 1048576 bytes, scanned %d.\n", result.Bytes)
+	}
+	if len(result.Regions) != 4 {
+		t.Errorf("Expected 4 regions, found %d.\n", len(result.Regions))
+	}
+	for _, region := range result.Regions {
+		if region.Reads != 4 || region.Errors != 0 {
+			t.Errorf("Region %d recorded %d reads and %d errors.\n", region.Offset, region.Reads, region.Errors)
+		}
+	}
+	if len(result.Ranges) != 0 {
+		t.Errorf("Expected no flagged ranges, found %d.\n", len(result.Ranges))
+	}
+}
+
+func TestScanTargetUnreadable(t *testing.T) {
+	// Reading a directory always fails, so every attempt of every chunk fails.
+	config := &ScanConfig{BlockSize: 65536, FileSize: 131072, RegionSize: 131072, Retries: 2}
+	result := scanTarget(t.TempDir(), config)
+	if len(result.Regions) != 1 {
+		t.Fatalf("Expected 1 region, found %d.\n", len(result.Regions))
+	}
+	if region := result.Regions[0]; region.Errors != 2 || region.Retries != 4 {
+		t.Errorf("Expected 2 errors and 4 retries, found %d errors and %d retries.\n", region.Errors, region.Retries)
+	}
+	if len(result.Ranges) != 1 || result.Ranges[0].Kind != ScanError {
+		t.Errorf("Expected one unreadable range, found %d ranges.\n", len(result.Ranges))
+	}
+}
+
+func TestScanAddRange(t *testing.T) {
+	result := &scanResult{}
+
+	result.addRange(ScanSlow, 0, 4096, time.Millisecond)
+	result.addRange(ScanSlow, 4096, 4096, 3*time.Millisecond)
+	result.addRange(ScanError, 8192, 4096, 0)
+	if len(result.Ranges) != 2 {
+		t.Fatalf("Expected 2 ranges, found %d.\n", len(result.Ranges))
+	}
+	if result.Ranges[0].End != 8192 || result.Ranges[0].Latency != 3*time.Millisecond {
+		t.Errorf("Slow ranges were not merged. End %d, latency %s\n", result.Ranges[0].End, result.Ranges[0].Latency)
+	}
+}
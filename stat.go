@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
-	"math"
 	"os"
 	"path"
 	"sort"
@@ -15,8 +14,10 @@ import (
 )
 
 type diskStats struct {
-	Device string
-	Stats  []*sysfsDiskStats
+	Device      string
+	Info        *DeviceInfo // Identity and queue settings when sampling started, when sysfs provides them
+	Stats       []*sysfsDiskStats
+	Temperature *temperatureStats // Temperature sensors of the device, when it has any
 }
 
 type IOStats struct {
@@ -28,12 +29,13 @@ type IOStats struct {
 type sysfsDiskStats struct {
 	DiscardIO      int // Completed discard IO requests
 	DiscardMerges  int
-	DiscardSectors int // 512 byte discards
-	DiscardTime    int // Product of requests waiting and milliseconds that requests have waited
-	InFlight       int // The number of IO requests that have been issued but not completed
-	IOTime         int // The amount of time (ms) during which IO has been queued
-	TimeInQueue    int // The product of queued IO request time and queued requests
-	ReadIO         int // Completed read IO requests
+	DiscardSectors int           // 512 byte discards
+	DiscardTime    int           // Product of requests waiting and milliseconds that requests have waited
+	InFlight       int           // The number of IO requests that have been issued but not completed
+	IOTime         int           // The amount of time (ms) during which IO has been queued
+	Offset         time.Duration // Time since the start of the run that the sample was taken
+	TimeInQueue    int           // The product of queued IO request time and queued requests
+	ReadIO         int           // Completed read IO requests
 	ReadMerges     int
 	ReadSectors    int // 512 byte reads
 	ReadTime       int // Product of requests waiting and milliseconds that requests have waited
@@ -44,28 +46,57 @@ type sysfsDiskStats struct {
 	WriteTime      int // Product of requests waiting and milliseconds that requests have waited
 }
 
-// TODO: Add stats for CPU, memory, and interrupts
+// SysStatsCollection samples device, CPU, memory, interrupt, pressure, vmstat, and Go runtime statistics
+// during a run. The CPU, Interrupts, Memory, Pressure, Runtime, and VM collectors are optional.
 type SysStatsCollection struct {
-	//CPU     []*cpuStats
-	//Memory  []*memoryStats
-	Disk      []*diskStats
-	Semaphore chan bool
-	t         *time.Ticker
+	CPU        *cpuStats
+	Disk       []*diskStats
+	Interrupts *interruptStats
+	Memory     *memoryStats
+	Pressure   *pressureStats
+	Runtime    *runtimeStats
+	VM         *vmStats
+	Interval   time.Duration // Time between samples
+	Semaphore  chan bool
+	Start      time.Time
+	done       chan bool
+	samples    int // Samples kept since the collection was last thinned
+	stride     int // Intervals between kept samples, which doubles each time the samples are thinned
+	t          *time.Ticker
+	ticks      int
 }
 
+const (
+	DefaultPercentiles   = "50,95,99,99.9,99.99"
+	DefaultStatsInterval = time.Second
+	MinStatsInterval     = 10 * time.Millisecond
+	MaxInterruptCounts   = 1 << 22 // Per-CPU interrupt counts kept in memory before the samples are thinned
+	MaxStatsSamples      = 3600    // Samples kept in memory by each collector before the samples are thinned
+)
+
+// SkewWarning is the fraction of a group's run time which worker start and stop skew may reach before
+// its aggregate throughput is flagged as unreliable.
+const SkewWarning = 0.1
+
+// Throughput holds the results of one worker, or the combined results of several workers. Combined
+// rates are the total bytes and operations over the wall-clock window from the first worker starting
+// to the last worker stopping, while WorkerRate sums each worker's own rate for reference.
 type Throughput struct {
-	ID              int
-	Latencies       []time.Duration
-	sortedLatencies []time.Duration
+	Bytes      int64
+	Histogram  *Histogram
+	ID         int
+	IOPS       float64
+	Rate       float64 // MiB/sec
+	Start      time.Time
+	Stop       time.Time
+	Usage      *ThreadUsage // CPU time and context switches of the workers' threads, when available
+	WorkerRate float64      // Sum of each worker's MiB/sec
+	firstStop  time.Time
+	lastStart  time.Time
 }
 
-type byDuration []time.Duration
 type byThroughputID []*Throughput
 
-func (d byDuration) Len() int           { return len(d) }
-func (d byDuration) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
-func (d byDuration) Less(i, j int) bool { return d[i] < d[j] }
-
 func (v byThroughputID) Len() int           { return len(v) }
 func (v byThroughputID) Swap(i, j int)      { v[i], v[j] = v[j], v[i] }
 func (v byThroughputID) Less(i, j int) bool { return v[i].ID < v[j].ID }
@@ -89,12 +120,13 @@ func (s *diskStats) String() string {
 	return fmt.Sprint(output)
 }
 
-func (s *diskStats) UpdateStats() error {
+// UpdateStats appends a sample of the device's statistics, taken start plus its offset into the run.
+func (s *diskStats) UpdateStats(start time.Time) error {
 	var statsFileData []byte
 	var stat sysfsDiskStats
 	var err error
 
-	statsFileData, err = ioutil.ReadFile(fmt.Sprintf("/sys/block/%s/stat", s.Device))
+	statsFileData, err = ioutil.ReadFile(path.Join(SysRoot, "block", s.Device, "stat"))
 	if err != nil {
 		return err
 	}
@@ -151,74 +183,16 @@ func (s *diskStats) UpdateStats() error {
 		}
 	}
 	stat.Time = time.Now()
+	stat.Offset = stat.Time.Sub(start)
 	s.Stats = append(s.Stats, &stat)
 
 	return nil
 }
 
-func (s *IOStats) Write(dir string) error {
-	//TODO: Output a timestamp or row count when writing latency data
-	writeStatsFile, writeFileError := os.OpenFile(path.Join(dir, "writers.csv"), os.O_CREATE|os.O_RDWR, 0644)
-	if writeFileError != nil {
-		return writeFileError
-	}
-
-	if _, err := writeStatsFile.WriteString("\"path\",\"worker id\",\"latency us\"\n"); err != nil {
-		log.Printf("ERROR: Unable to write to writer stats file. %s\n", err)
-		return err
-	}
-	for key, value := range s.WriteThroughput {
-		sort.Sort(byThroughputID(value))
-
-		for _, item := range value {
-			for _, latency := range item.Latencies {
-				if _, err := writeStatsFile.WriteString(fmt.Sprintf("\"%s\",%d,%d\n", key, item.ID, latency.Microseconds())); err != nil {
-					log.Printf("ERROR: Unable to write to writer stats file. %s\n", err)
-					return err
-				}
-			}
-		}
-	}
-	_ = writeStatsFile.Sync()
-	if closeErr := writeStatsFile.Close(); closeErr != nil {
-		log.Printf("ERROR: Unable to close writer stats file. %s\n", closeErr)
-		return closeErr
-	}
-
-	readStatsFile, readFileError := os.OpenFile(path.Join(dir, "readers.csv"), os.O_CREATE|os.O_RDWR, 0644)
-	if readFileError != nil {
-		return readFileError
-	}
-
-	if _, err := readStatsFile.WriteString("\"path\",\"worker id\",\"latency us\"\n"); err != nil {
-		log.Printf("ERROR: Unable to write to reader stats file. %s\n", err)
-		return err
-	}
-	for key, value := range s.ReadThroughput {
-		sort.Sort(byThroughputID(value))
-
-		for _, item := range value {
-			for _, latency := range item.Latencies {
-				if _, err := readStatsFile.WriteString(fmt.Sprintf("\"%s\",%d,%d\n", key, item.ID, latency.Microseconds())); err != nil {
-					log.Printf("ERROR: Unable to write to reader stats file. %s\n", err)
-					return err
-				}
-			}
-		}
-	}
-	_ = readStatsFile.Sync()
-	if closeErr := readStatsFile.Close(); closeErr != nil {
-		log.Printf("ERROR: Unable to close reader stats file. %s\n", closeErr)
-		return closeErr
-	}
-
-	return nil
-}
-
 func (s *sysfsDiskStats) Csv() string {
 	var output string
 
-	output += fmt.Sprintf("%d,", s.Time.Unix())
+	output += fmt.Sprintf("%d,", s.Offset.Milliseconds())
 
 	output += fmt.Sprintf("%d,", s.ReadIO)
 	output += fmt.Sprintf("%d,", s.ReadMerges)
@@ -271,34 +245,229 @@ func (s *SysStatsCollection) Add(device string) {
 		}
 	}
 
+	// Paths which are not on a block device have no device name, settings, or sensors.
+	if device != "" {
+		if info, err := readDeviceInfo(device); err != nil {
+			log.Printf("WARNING: Unable to read the settings of device %s. %s\n", device, err)
+		} else {
+			d.Info = info
+		}
+		d.Temperature = newTemperatureStats(device)
+		if s.Interrupts != nil {
+			s.Interrupts.Names = append(s.Interrupts.Names, deviceIRQNames(device)...)
+		}
+	}
+
 	s.Disk = append(s.Disk, &d)
 }
 
+// CollectStats samples every device immediately, then every interval, and once more when Stop is called,
+// so the first and last samples cover the whole run.
 func (s *SysStatsCollection) CollectStats() {
-	s.t = time.NewTicker(1 * time.Second)
+	if s.Interval <= 0 {
+		s.Interval = DefaultStatsInterval
+	}
+	if s.Start.IsZero() {
+		s.Start = time.Now()
+	}
+	s.done = make(chan bool)
+	s.stride = 1
+
+	s.sample()
+	s.t = time.NewTicker(s.Interval)
 	for {
 		select {
 		case <-s.Semaphore:
 			s.t.Stop()
+			s.sample()
+			s.done <- true
 			return
 		case <-s.t.C:
-			for _, item := range s.Disk {
-				if Debug {
-					log.Printf("Updating stats for %s\n", item.Device)
-				}
+			s.ticks++
+			if s.ticks%s.stride == 0 {
+				s.sample()
+				s.thin()
+			}
+		}
+	}
+}
 
-				if err := item.UpdateStats(); err != nil {
-					log.Printf("Error updating stats for %s. %s\n", item.Device, err)
-				}
+// decimate drops every other sample, keeping the first.
+func decimate[T any](samples []T) []T {
+	kept := samples[:0]
+	for i := 0; i < len(samples); i += 2 {
+		kept = append(kept, samples[i])
+	}
+	// Release the dropped samples.
+	var empty T
+	for i := len(kept); i < len(samples); i++ {
+		samples[i] = empty
+	}
+	return kept
+}
+
+// thin halves the resolution of every collector once they hold MaxStatsSamples samples, or the interrupt
+// samples hold MaxInterruptCounts counts, and from then on samples every other interval, so a long run is
+// covered end to end in bounded memory.
+func (s *SysStatsCollection) thin() {
+	if s.samples < MaxStatsSamples && (s.Interrupts == nil || s.Interrupts.counts < MaxInterruptCounts) {
+		return
+	}
+	for _, item := range s.Disk {
+		item.Stats = decimate(item.Stats)
+	}
+	if s.CPU != nil {
+		s.CPU.Samples = decimate(s.CPU.Samples)
+	}
+	if s.Memory != nil {
+		s.Memory.Samples = decimate(s.Memory.Samples)
+	}
+	if s.Interrupts != nil {
+		s.Interrupts.Samples = decimate(s.Interrupts.Samples)
+		s.Interrupts.counts = 0
+		for _, sample := range s.Interrupts.Samples {
+			s.Interrupts.counts += sample.counts()
+		}
+	}
+	if s.Pressure != nil {
+		s.Pressure.Samples = decimate(s.Pressure.Samples)
+	}
+	if s.VM != nil {
+		s.VM.Samples = decimate(s.VM.Samples)
+	}
+	if s.Runtime != nil {
+		s.Runtime.Samples = decimate(s.Runtime.Samples)
+	}
+	s.samples = (s.samples + 1) / 2
+	s.stride *= 2
+	if Verbose {
+		log.Printf("Thinned stats samples, now keeping one sample every %s.\n", s.Interval*time.Duration(s.stride))
+	}
+}
+
+func (s *SysStatsCollection) sample() {
+	s.samples++
+	for _, item := range s.Disk {
+		if Debug {
+			log.Printf("Updating stats for %s\n", item.Device)
+		}
+
+		if err := item.UpdateStats(s.Start); err != nil {
+			log.Printf("Error updating stats for %s. %s\n", item.Device, err)
+		}
+		if item.Temperature != nil {
+			if err := item.Temperature.UpdateStats(s.Start); err != nil {
+				log.Printf("Error updating temperatures for %s. %s\n", item.Device, err)
 			}
 		}
 	}
+
+	if s.CPU != nil {
+		if err := s.CPU.UpdateStats(s.Start); err != nil {
+			log.Printf("Error updating CPU stats. %s\n", err)
+		}
+	}
+	if s.Memory != nil {
+		if err := s.Memory.UpdateStats(s.Start); err != nil {
+			log.Printf("Error updating memory stats. %s\n", err)
+		}
+	}
+	if s.Interrupts != nil {
+		if err := s.Interrupts.UpdateStats(s.Start); err != nil {
+			log.Printf("Error updating interrupt stats. %s\n", err)
+		}
+	}
+	if s.Pressure != nil {
+		if err := s.Pressure.UpdateStats(s.Start); err != nil {
+			log.Printf("Error updating pressure stats. %s\n", err)
+		}
+	}
+	if s.VM != nil {
+		if err := s.VM.UpdateStats(s.Start); err != nil {
+			log.Printf("Error updating vmstat stats. %s\n", err)
+		}
+	}
+	if s.Runtime != nil {
+		if err := s.Runtime.UpdateStats(s.Start); err != nil {
+			log.Printf("Error updating Go runtime stats. %s\n", err)
+		}
+	}
+}
+
+// WriteSystem saves the CPU, memory, interrupt, pressure, vmstat, and Go runtime samples to cpu.csv,
+// meminfo.csv, interrupts.csv, psi.csv, vmstat.csv, runtime.csv, and gcpauses.csv in dir.
+func (s *SysStatsCollection) WriteSystem(dir string) error {
+	if s.CPU != nil {
+		if err := writeStatsFile(dir, "cpu.csv", s.CPU.Csv()); err != nil {
+			return err
+		}
+	}
+	if s.Memory != nil {
+		if err := writeStatsFile(dir, "meminfo.csv", s.Memory.Csv()); err != nil {
+			return err
+		}
+	}
+	if s.Interrupts != nil {
+		if err := writeStatsFile(dir, "interrupts.csv", s.Interrupts.Csv()); err != nil {
+			return err
+		}
+	}
+	if s.Pressure != nil {
+		if err := writeStatsFile(dir, "psi.csv", s.Pressure.Csv()); err != nil {
+			return err
+		}
+	}
+	if s.VM != nil {
+		if err := writeStatsFile(dir, "vmstat.csv", s.VM.Csv()); err != nil {
+			return err
+		}
+	}
+	if s.Runtime != nil {
+		if err := writeStatsFile(dir, "runtime.csv", s.Runtime.Csv()); err != nil {
+			return err
+		}
+		if err := writeStatsFile(dir, "gcpauses.csv", s.Runtime.PausesCsv()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SystemSummary returns the CPU, interrupt, pressure, page cache, vmstat, and Go runtime summaries of the run.
+func (s *SysStatsCollection) SystemSummary() string {
+	var output string
+
+	if s.CPU != nil {
+		output += s.CPU.Summary()
+	}
+	if s.Interrupts != nil {
+		output += s.Interrupts.Summary()
+	}
+	if s.Pressure != nil {
+		output += s.Pressure.Summary()
+	}
+	if s.Memory != nil {
+		output += s.Memory.PageCacheSummary()
+	}
+	if s.VM != nil {
+		output += s.VM.Summary()
+	}
+	if s.Runtime != nil {
+		output += s.Runtime.Summary()
+	}
+	return output
+}
+
+// Stop takes a final sample and waits for CollectStats to return.
+func (s *SysStatsCollection) Stop() {
+	s.Semaphore <- true
+	<-s.done
 }
 
 func (s *SysStatsCollection) Csv() string {
 	var output string
 
-	output += "\"device\",\"timestamp\",\"read IO\",\"read merges\",\"read sectors\",\"read time\",\"write IO\",\"write merges\",\"write sectors\",\"write time\",\"inflight\",\"IO time\",\"time in queue\"\n"
+	output += "\"device\",\"time ms\",\"read IO\",\"read merges\",\"read sectors\",\"read time\",\"write IO\",\"write merges\",\"write sectors\",\"write time\",\"inflight\",\"IO time\",\"time in queue\"\n"
 	for _, item := range s.Disk {
 		output += fmt.Sprintf("%s\n", item.Csv())
 	}
@@ -316,13 +485,13 @@ func (s *SysStatsCollection) String() string {
 
 func (s *SysStatsCollection) Write(dir string) error {
 	for _, value := range s.Disk {
-		diskStatsFile, diskFileError := os.OpenFile(path.Join(dir, fmt.Sprintf("diskstats.%s.csv", value.Device)), os.O_CREATE|os.O_RDWR, 0644)
+		diskStatsFile, diskFileError := os.OpenFile(path.Join(dir, fmt.Sprintf("diskstats.%s.csv", value.Device)), os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0644)
 		if diskFileError != nil {
 			return diskFileError
 		}
 
 		if _, err := diskStatsFile.WriteString("device" +
-			",\"time\",\"reads completed\",\"read merges\",\"read sectors\",\"read time\"" +
+			",\"time ms\",\"reads completed\",\"read merges\",\"read sectors\",\"read time\"" +
 			",\"writes completed\",\"write merges\",\"write sectors\",\"write time\"" +
 			",\"in flight\",\"io time\",\"time in queue\"\n"); err != nil {
 			log.Printf("ERROR: Unable to write to writer stats file. %s\n", err)
@@ -341,65 +510,269 @@ func (s *SysStatsCollection) Write(dir string) error {
 			log.Printf("ERROR: Unable to close disk stats file. %s\n", closeErr)
 			return closeErr
 		}
+
+		if value.Info != nil {
+			if err := writeStatsFile(dir, fmt.Sprintf("device.%s.csv", value.Device), value.Info.Csv()); err != nil {
+				return err
+			}
+		}
+		if value.Temperature != nil {
+			if err := writeStatsFile(dir, fmt.Sprintf("temperature.%s.csv", value.Device), value.Temperature.Csv()); err != nil {
+				return err
+			}
+		}
 	}
 
 	return nil
 }
 
 func (t *Throughput) Average() time.Duration {
-	var totalTime time.Duration
+	return t.Histogram.Mean()
+}
 
-	for _, value := range t.Latencies {
-		totalTime += value
+func (t *Throughput) Max() time.Duration {
+	return t.Histogram.Max()
+}
+
+func (t *Throughput) Min() time.Duration {
+	return t.Histogram.Min()
+}
+
+func (t *Throughput) Percentile(q float64) time.Duration {
+	return t.Histogram.Percentile(q)
+}
+
+func (t *Throughput) Ops() int64 {
+	return t.Histogram.Count()
+}
+
+func (t *Throughput) String() string {
+	percentiles, _ := parsePercentiles(DefaultPercentiles)
+	return t.Summary(percentiles)
+}
+
+// Summary returns the rates, operation count, and latency at each of percentiles, from 0 to 100.
+func (t *Throughput) Summary(percentiles []float64) string {
+	output := fmt.Sprintf(
+		"%0.2f MiB/sec, %0.0f IOPS, %d ops. Min: %d us, Avg: %d us",
+		t.Rate, t.IOPS, t.Ops(), t.Min().Microseconds(), t.Average().Microseconds(),
+	)
+	for _, p := range percentiles {
+		output += fmt.Sprintf(", %s: %d us", percentileName(p), t.Percentile(p/100).Microseconds())
 	}
+	output += fmt.Sprintf(", Max: %d us", t.Max().Microseconds())
+	if t.Usage != nil {
+		output += fmt.Sprintf(
+			". CPU: %0.1f us/op (user %d ms, sys %d ms), switches: %d voluntary, %d involuntary",
+			t.Usage.PerOp(t.Ops()), t.Usage.User.Milliseconds(), t.Usage.System.Milliseconds(), t.Usage.Voluntary, t.Usage.Involuntary,
+		)
+	}
+	return output
+}
 
-	return time.Duration(int64(totalTime) / int64(len(t.Latencies)))
+// newThroughput returns the results of a worker which transferred bytes between start and stop, using
+// usage CPU time. usage may be nil.
+func newThroughput(id int, bytes int64, start time.Time, stop time.Time, histogram *Histogram, usage *ThreadUsage) *Throughput {
+	if histogram == nil {
+		histogram = NewHistogram(HistogramPrecision)
+	}
+	t := &Throughput{Bytes: bytes, Histogram: histogram, ID: id, Start: start, Stop: stop, Usage: usage, firstStop: stop, lastStart: start}
+	t.rates()
+	t.WorkerRate = t.Rate
+	return t
 }
 
-func (t *Throughput) Max() time.Duration {
-	if len(t.Latencies) == 0 {
-		return 0
+func (t *Throughput) rates() {
+	if elapsed := t.Stop.Sub(t.Start); elapsed > 0 {
+		t.IOPS = float64(t.Ops()) / elapsed.Seconds()
+		t.Rate = float64(t.Bytes) / MiB / elapsed.Seconds()
 	}
+}
 
-	t.Sort()
-	return t.sortedLatencies[len(t.sortedLatencies)-1]
+// Elapsed returns the wall-clock time from the first worker starting to the last worker stopping.
+func (t *Throughput) Elapsed() time.Duration {
+	return t.Stop.Sub(t.Start)
 }
 
-func (t *Throughput) Min() time.Duration {
-	if len(t.Latencies) == 0 {
-		return 0
+// Skew returns the time between the first and last workers starting, plus the time between the first
+// and last workers stopping. Workers only ran concurrently for the elapsed time less the skew.
+func (t *Throughput) Skew() time.Duration {
+	return t.lastStart.Sub(t.Start) + t.Stop.Sub(t.firstStop)
+}
+
+// Skewed reports whether the skew is large enough relative to the elapsed time that the combined rate
+// differs noticeably from the rate the workers reached while running together.
+func (t *Throughput) Skewed() bool {
+	return t.Elapsed() > 0 && t.Skew().Seconds() > SkewWarning*t.Elapsed().Seconds()
+}
+
+// mergeThroughput combines throughputs, such as every worker of a path. Bytes and thread usage are
+// summed, latency histograms are merged, and rates are calculated over the combined wall-clock window.
+func mergeThroughput(id int, throughputs ...*Throughput) (*Throughput, error) {
+	merged := &Throughput{ID: id}
+
+	for i, t := range throughputs {
+		if merged.Histogram == nil {
+			merged.Histogram = NewHistogram(t.Histogram.precision)
+		}
+		if err := merged.Histogram.Merge(t.Histogram); err != nil {
+			return nil, err
+		}
+		merged.Bytes += t.Bytes
+		merged.WorkerRate += t.WorkerRate
+		if t.Usage != nil {
+			if merged.Usage == nil {
+				merged.Usage = &ThreadUsage{}
+			}
+			merged.Usage.Add(t.Usage)
+		}
+
+		if i == 0 || t.Start.Before(merged.Start) {
+			merged.Start = t.Start
+		}
+		if i == 0 || t.Stop.After(merged.Stop) {
+			merged.Stop = t.Stop
+		}
+		if i == 0 || t.firstStop.Before(merged.firstStop) {
+			merged.firstStop = t.firstStop
+		}
+		if i == 0 || t.lastStart.After(merged.lastStart) {
+			merged.lastStart = t.lastStart
+		}
 	}
+	if merged.Histogram == nil {
+		merged.Histogram = NewHistogram(HistogramPrecision)
+	}
+	merged.rates()
+	return merged, nil
+}
+
+// parsePercentiles parses a comma separated list of percentiles, such as 50,99,99.9.
+func parsePercentiles(s string) ([]float64, error) {
+	var percentiles []float64
+
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		p, err := strconv.ParseFloat(strings.TrimPrefix(strings.ToLower(item), "p"), 64)
+		if err != nil || p <= 0 || p > 100 {
+			return nil, fmt.Errorf("invalid percentile %q", item)
+		}
+		percentiles = append(percentiles, p)
+	}
+	if len(percentiles) == 0 {
+		return nil, fmt.Errorf("no percentiles in %q", s)
+	}
+	return percentiles, nil
+}
 
-	t.Sort()
-	return t.sortedLatencies[0]
+func percentileName(p float64) string {
+	return "P" + strconv.FormatFloat(p, 'f', -1, 64)
 }
 
-func (t *Throughput) Percentile(q float64) time.Duration {
-	t.Sort()
+// ThroughputGroup holds the combined results of the workers of a device, a path, or a file. Devices
+// hold paths, paths hold files, and files hold workers.
+type ThroughputGroup struct {
+	Groups  []*ThroughputGroup
+	Name    string
+	Total   *Throughput
+	Workers []*Throughput
+	members []*Throughput
+}
+
+// ThroughputTree aggregates worker results by file, by the path holding each file, and by the device
+// holding each path, up to the total of every worker.
+type ThroughputTree struct {
+	Devices []*ThroughputGroup
+	Total   *Throughput
+}
 
-	k := float64(len(t.sortedLatencies)-1) * q
-	floor := math.Floor(k)
-	ceiling := math.Ceil(k)
-	if floor == ceiling {
-		return t.sortedLatencies[int(k)]
+func (g *ThroughputGroup) child(name string) *ThroughputGroup {
+	for _, c := range g.Groups {
+		if c.Name == name {
+			return c
+		}
 	}
+	c := &ThroughputGroup{Name: name}
+	g.Groups = append(g.Groups, c)
+	return c
+}
 
-	return (t.sortedLatencies[int(floor)] + t.sortedLatencies[int(ceiling)]) / 2
+func (g *ThroughputGroup) merge() error {
+	for _, c := range g.Groups {
+		if err := c.merge(); err != nil {
+			return err
+		}
+	}
+	merged, err := mergeThroughput(-1, g.members...)
+	g.Total = merged
+	return err
 }
 
-func (t *Throughput) Sort() {
-	if len(t.sortedLatencies) != len(t.Latencies) {
-		t.sortedLatencies = make([]time.Duration, len(t.Latencies))
-		copy(t.sortedLatencies, t.Latencies)
-		sort.Sort(byDuration(t.sortedLatencies))
+// groupThroughput builds a ThroughputTree from the results of every file in files. parents maps files
+// to the path holding them, and devices maps paths to the device holding them.
+func groupThroughput(results map[string][]*Throughput, files []string, parents map[string]string, devices map[string]string) (*ThroughputTree, error) {
+	root := &ThroughputGroup{}
+
+	seen := make(map[string]bool)
+	for _, file := range files {
+		if seen[file] {
+			continue
+		}
+		seen[file] = true
+
+		workers := results[file]
+		sort.Sort(byThroughputID(workers))
+		parent := parents[file]
+		device := root.child(devices[parent])
+		ioPath := device.child(parent)
+		f := ioPath.child(file)
+		f.Workers = append(f.Workers, workers...)
+		for _, g := range []*ThroughputGroup{root, device, ioPath, f} {
+			g.members = append(g.members, workers...)
+		}
 	}
+
+	if err := root.merge(); err != nil {
+		return nil, err
+	}
+	return &ThroughputTree{Devices: root.Groups, Total: root.Total}, nil
 }
 
-func (t *Throughput) String() string {
-	return fmt.Sprintf(
-		"Min: %d us, Max: %d us, Avg: %d us, P50: %d us, P95: %d us, P99: %d us",
-		t.Min().Microseconds(), t.Max().Microseconds(),
-		t.Average().Microseconds(), t.Percentile(0.50).Microseconds(),
-		t.Percentile(0.95).Microseconds(), t.Percentile(0.99).Microseconds(),
-	)
+// Summary returns an indented line for every device, path, file, and worker, followed by a line for
+// all of the workers together, labeled total. Device, path, file, and total rates cover the wall-clock
+// window their workers ran in, and a warning is added when worker start and stop times are skewed.
+func (t *ThroughputTree) Summary(total string, percentiles []float64) string {
+	var output string
+
+	for _, device := range t.Devices {
+		name := device.Name
+		if name == "" {
+			name = "unknown device"
+		}
+		output += fmt.Sprintf("%s: %s\n", name, device.Total.Summary(percentiles))
+		for _, ioPath := range device.Groups {
+			output += fmt.Sprintf("  %s: %s\n", ioPath.Name, ioPath.Total.Summary(percentiles))
+			for _, file := range ioPath.Groups {
+				if file.Name != ioPath.Name {
+					// Devices and special files are their own path, so they are only listed once.
+					output += fmt.Sprintf("    %s: %s\n", file.Name, file.Total.Summary(percentiles))
+				}
+				for _, w := range file.Workers {
+					output += fmt.Sprintf("      [%d]: %s\n", w.ID, w.Summary(percentiles))
+				}
+			}
+		}
+	}
+
+	output += fmt.Sprintf("%s: %s\n", total, t.Total.Summary(percentiles))
+	if t.Total.Skewed() {
+		output += fmt.Sprintf(
+			"WARNING: Workers started and stopped %0.2f sec. apart in a %0.2f sec. run, so they did not run concurrently throughout. The sum of worker rates is %0.2f MiB/sec.\n",
+			t.Total.Skew().Seconds(), t.Total.Elapsed().Seconds(), t.Total.WorkerRate,
+		)
+	}
+	return output
 }
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParsePercentiles(t *testing.T) {
+	percentiles, err := parsePercentiles("50, p99,99.99")
+	if err != nil || len(percentiles) != 3 || percentiles[1] != 99 || percentiles[2] != 99.99 {
+		t.Errorf("Unexpected percentiles %v. %v\n", percentiles, err)
+	}
+	for _, invalid := range []string{"", "0", "101", "fast"} {
+		if _, err := parsePercentiles(invalid); err == nil {
+			t.Errorf("Percentiles %q should be invalid.\n", invalid)
+		}
+	}
+	if name := percentileName(99.9); name != "P99.9" {
+		t.Errorf("Unexpected percentile name %s\n", name)
+	}
+}
+
+func TestThroughputTree(t *testing.T) {
+	var throughputs []*Throughput
+	start := time.Now()
+
+	for id := 0; id < 3; id++ {
+		h := NewHistogram(HistogramPrecision)
+		for i := 0; i < 1000; i++ {
+			h.Record(time.Duration(id+1) * time.Millisecond)
+		}
+		throughputs = append(throughputs, newThroughput(id, 1000*MiB, start, start.Add(10*time.Second), h, nil))
+	}
+	results := map[string][]*Throughput{
+		"/a/scriba.0.data": {throughputs[1], throughputs[0]},
+		"/b/scriba.0.data": {throughputs[2]},
+		"/dev/sdc":         {throughputs[0]},
+	}
+	files := []string{"/a/scriba.0.data", "/b/scriba.0.data", "/dev/sdc"}
+	parents := map[string]string{"/a/scriba.0.data": "/a", "/b/scriba.0.data": "/b", "/dev/sdc": "/dev/sdc"}
+	devices := map[string]string{"/a": "sda", "/b": "sda", "/dev/sdc": "sdc"}
+
+	tree, err := groupThroughput(results, files, parents, devices)
+	if err != nil {
+		t.Fatalf("Unable to group throughput. %s\n", err)
+	}
+	if len(tree.Devices) != 2 || len(tree.Devices[0].Groups) != 2 || tree.Devices[0].Total.Rate != 300 || tree.Total.Rate != 400 {
+		t.Fatalf("Unexpected throughput tree %+v\n", tree)
+	}
+	if file := tree.Devices[0].Groups[0].Groups[0]; file.Total.Rate != 200 || file.Workers[0].ID != 0 {
+		t.Errorf("Unexpected file group %+v\n", file)
+	}
+
+	lines := strings.Split(strings.TrimSpace(tree.Summary("Read Total", []float64{50, 99.99})), "\n")
+	expected := []string{
+		"sda: 300.00 MiB/sec",
+		"  /a: 200.00 MiB/sec",
+		"    /a/scriba.0.data: 200.00 MiB/sec",
+		"      [0]: 100.00 MiB/sec, 100 IOPS, 1000 ops. Min: 1000 us, Avg: 1000 us, P50: 1000 us, P99.99: 1000 us, Max: 1000 us",
+		"      [1]: 100.00 MiB/sec",
+		"  /b: 100.00 MiB/sec",
+		"    /b/scriba.0.data: 100.00 MiB/sec",
+		"      [2]: 100.00 MiB/sec",
+		"sdc: 100.00 MiB/sec",
+		"  /dev/sdc: 100.00 MiB/sec",
+		"      [0]: 100.00 MiB/sec",
+		"Read Total: 400.00 MiB/sec, 400 IOPS, 4000 ops. Min: 1000 us, Avg: 1750 us, P50: 1000 us, P99.99: 3000 us, Max: 3000 us",
+	}
+	if len(lines) != len(expected) {
+		t.Fatalf("Unexpected summary %q\n", lines)
+	}
+	for i, prefix := range expected {
+		if !strings.HasPrefix(lines[i], prefix) {
+			t.Errorf("Expected line %d to start with %q, found %q\n", i, prefix, lines[i])
+		}
+	}
+}
+
+func TestThroughputWindow(t *testing.T) {
+	start := time.Now()
+
+	// Two workers each writing 100MiB/sec, one starting as the other stops, only ever reach 100MiB/sec together.
+	first := newThroughput(0, 1000*MiB, start, start.Add(10*time.Second), NewHistogram(HistogramPrecision), nil)
+	second := newThroughput(1, 1000*MiB, start.Add(10*time.Second), start.Add(20*time.Second), NewHistogram(HistogramPrecision), nil)
+	merged, err := mergeThroughput(-1, first, second)
+	if err != nil {
+		t.Fatalf("Unable to merge throughputs. %s\n", err)
+	}
+	if merged.Rate != 100 || merged.WorkerRate != 200 || merged.Elapsed() != 20*time.Second {
+		t.Errorf("Unexpected merged rates %0.2f and %0.2f over %s\n", merged.Rate, merged.WorkerRate, merged.Elapsed())
+	}
+	if merged.Skew() != 20*time.Second || !merged.Skewed() {
+		t.Errorf("Unexpected skew %s\n", merged.Skew())
+	}
+
+	// Workers which start and stop within a fraction of the run are not flagged.
+	second = newThroughput(1, 1000*MiB, start.Add(100*time.Millisecond), start.Add(10*time.Second), NewHistogram(HistogramPrecision), nil)
+	if merged, _ = mergeThroughput(-1, first, second); merged.Skewed() || merged.Skew() != 100*time.Millisecond {
+		t.Errorf("Unexpected skew %s\n", merged.Skew())
+	}
+}
+
+func TestDiskStatsCsvOffset(t *testing.T) {
+	stat := &sysfsDiskStats{Offset: 1500 * time.Millisecond, ReadIO: 3, Time: time.Now()}
+	if csv := stat.Csv(); !strings.HasPrefix(csv, "1500,3,") {
+		t.Errorf("Samples should be timed in milliseconds since the start of the run. %s\n", csv)
+	}
+}
+
+func TestThinStats(t *testing.T) {
+	s := &SysStatsCollection{CPU: &cpuStats{}, Disk: []*diskStats{{Device: "sda"}}, Interrupts: &interruptStats{}, stride: 1}
+	for i := 0; i < MaxStatsSamples; i++ {
+		offset := time.Duration(i) * time.Second
+		s.CPU.Samples = append(s.CPU.Samples, &cpuSample{Offset: offset})
+		s.Disk[0].Stats = append(s.Disk[0].Stats, &sysfsDiskStats{Offset: offset})
+		s.Interrupts.Samples = append(s.Interrupts.Samples, &interruptSample{Lines: []*interruptLine{{Counts: []int64{1, 2}}}, Offset: offset})
+		s.Interrupts.counts += 2
+		s.samples++
+		s.thin()
+	}
+
+	if s.stride != 2 || s.samples != MaxStatsSamples/2 {
+		t.Fatalf("Expected a stride of 2 and %d samples, found %d and %d\n", MaxStatsSamples/2, s.stride, s.samples)
+	}
+	if len(s.CPU.Samples) != MaxStatsSamples/2 || len(s.Disk[0].Stats) != MaxStatsSamples/2 || s.Interrupts.counts != MaxStatsSamples {
+		t.Fatalf("Unexpected thinned samples: %d CPU, %d disk, %d interrupt counts\n", len(s.CPU.Samples), len(s.Disk[0].Stats), s.Interrupts.counts)
+	}
+	for i, sample := range s.CPU.Samples {
+		if sample.Offset != time.Duration(2*i)*time.Second {
+			t.Fatalf("Sample %d is at %s, not %ds\n", i, sample.Offset, 2*i)
+		}
+	}
+}
+
+func TestSysStatsAdd(t *testing.T) {
+	var logged bytes.Buffer
+	fakeRoot(t, map[string]string{})
+	log.SetOutput(&logged)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	var s SysStatsCollection
+	s.Add("")
+	s.Add("")
+	if len(s.Disk) != 1 || s.Disk[0].Info != nil || s.Disk[0].Temperature != nil {
+		t.Errorf("Unexpected disks for a path without a device. %+v\n", s.Disk)
+	}
+	if logged.Len() != 0 {
+		t.Errorf("Unexpected warning for a path without a device. %q\n", logged.String())
+	}
+
+	// Duplicates are dropped before the device is read, so only the first Add warns.
+	s.Add("sdz")
+	s.Add("sdz")
+	if len(s.Disk) != 2 || strings.Count(logged.String(), "WARNING") != 1 {
+		t.Errorf("Expected one more disk and one warning, found %d disks. %q\n", len(s.Disk), logged.String())
+	}
+}
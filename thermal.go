@@ -0,0 +1,303 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	TemperatureInterval = time.Second // Minimum time between temperature samples, since an NVMe sensor read is an admin command
+	ThrottleDrop        = 0.2         // Fraction throughput must fall below its recent average to count as a drop
+	ThrottleWindow      = 5           // Intervals averaged to find the throughput a drop is measured from
+)
+
+// temperatureSensor is one temp*_input file of a device's hwmon directory.
+type temperatureSensor struct {
+	Input     string  // Path of the temp*_input file
+	Name      string  // The sensor's label, such as Composite, or hwmon name and sensor number
+	Threshold float64 // The sensor's warning temperature in degrees C, or its critical temperature, or 0
+}
+
+type temperatureSample struct {
+	Offset time.Duration
+	Values []float64 // Degrees C for each sensor, in the order of temperatureStats.Sensors
+}
+
+// temperatureStats samples the temperature sensors of a block device.
+type temperatureStats struct {
+	Interval time.Duration // Minimum time between samples, which doubles each time the samples are thinned
+	Samples  []*temperatureSample
+	Sensors  []*temperatureSensor
+}
+
+// ThrottleEvent is an interval in which a device's throughput fell while a sensor was at or above its threshold.
+type ThrottleEvent struct {
+	Baseline    float64 `json:"baseline_mib_per_sec"` // The average read and write MiB/sec of the preceding intervals
+	Rate        float64 `json:"mib_per_sec"`
+	Sensor      string  `json:"sensor"`
+	Temperature float64 `json:"temperature_c"`
+	Threshold   float64 `json:"threshold_c"`
+	Time        int64   `json:"time_ms"` // Time since the start of the run at the end of the interval
+}
+
+// TemperatureSensorSummary is the range of one sensor over the run.
+type TemperatureSensorSummary struct {
+	Max       float64 `json:"max_c"`
+	Min       float64 `json:"min_c"`
+	Name      string  `json:"name"`
+	Threshold float64 `json:"threshold_c"`
+}
+
+// TemperatureSummary is the range of every sensor of a device, and the throughput drops which coincided
+// with a sensor reaching its threshold.
+type TemperatureSummary struct {
+	Events  []*ThrottleEvent            `json:"throttle_events"`
+	Sensors []*TemperatureSensorSummary `json:"sensors"`
+}
+
+// deviceHwmonDirs returns the hwmon directories of a block device: those linked below the device, as NVMe
+// controllers and drivetemp provide, and any in /sys/class/hwmon whose device is the block device's.
+func deviceHwmonDirs(device string) []string {
+	var dirs []string
+	seen := make(map[string]bool)
+	add := func(dir string) {
+		resolved, err := filepath.EvalSymlinks(dir)
+		if err != nil || seen[resolved] {
+			return
+		}
+		seen[resolved] = true
+		dirs = append(dirs, dir)
+	}
+
+	deviceDir := path.Join(SysRoot, "block", device, "device")
+	for _, pattern := range []string{"hwmon*", path.Join("hwmon", "hwmon*")} {
+		matches, _ := filepath.Glob(path.Join(deviceDir, pattern))
+		for _, match := range matches {
+			if _, err := os.Stat(path.Join(match, "name")); err == nil || len(temperatureInputs(match)) > 0 {
+				add(match)
+			}
+		}
+	}
+
+	if target, err := filepath.EvalSymlinks(deviceDir); err == nil {
+		matches, _ := filepath.Glob(path.Join(SysRoot, "class", "hwmon", "hwmon*"))
+		for _, match := range matches {
+			if hwmonDevice, err := filepath.EvalSymlinks(path.Join(match, "device")); err == nil && hwmonDevice == target {
+				add(match)
+			}
+		}
+	}
+	return dirs
+}
+
+// temperatureInputs returns the temp*_input files of an hwmon directory, sorted by sensor number.
+func temperatureInputs(dir string) []string {
+	inputs, _ := filepath.Glob(path.Join(dir, "temp*_input"))
+	sort.Slice(inputs, func(i, j int) bool {
+		return sensorNumber(inputs[i]) < sensorNumber(inputs[j])
+	})
+	return inputs
+}
+
+func sensorNumber(input string) int {
+	n, _ := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(path.Base(input), "temp"), "_input"))
+	return n
+}
+
+// readMillidegrees returns a temperature file's value in degrees C.
+func readMillidegrees(file string) (float64, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return 0, err
+	}
+	value, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return float64(value) / 1000, nil
+}
+
+// newTemperatureStats returns a collector for every temperature sensor of device, or nil when it has none.
+func newTemperatureStats(device string) *temperatureStats {
+	s := &temperatureStats{Interval: TemperatureInterval}
+
+	for _, dir := range deviceHwmonDirs(device) {
+		name := path.Base(dir)
+		if data, err := os.ReadFile(path.Join(dir, "name")); err == nil {
+			name = strings.TrimSpace(string(data))
+		}
+		for _, input := range temperatureInputs(dir) {
+			prefix := strings.TrimSuffix(input, "_input")
+			sensor := &temperatureSensor{Input: input, Name: fmt.Sprintf("%s temp%d", name, sensorNumber(input))}
+			if data, err := os.ReadFile(prefix + "_label"); err == nil {
+				sensor.Name = strings.TrimSpace(string(data))
+			}
+			// Use the warning temperature, where a drive typically starts throttling, before the critical one.
+			for _, suffix := range []string{"_max", "_crit"} {
+				if threshold, err := readMillidegrees(prefix + suffix); err == nil && threshold > 0 {
+					sensor.Threshold = threshold
+					break
+				}
+			}
+			s.Sensors = append(s.Sensors, sensor)
+		}
+	}
+
+	if len(s.Sensors) == 0 {
+		return nil
+	}
+	return s
+}
+
+// UpdateStats samples every sensor, unless the last sample was taken less than Interval ago. Once
+// MaxStatsSamples are kept, every other sample is dropped and the interval doubles.
+func (s *temperatureStats) UpdateStats(start time.Time) error {
+	offset := time.Now().Sub(start)
+	if len(s.Samples) > 0 && offset-s.Samples[len(s.Samples)-1].Offset < s.Interval {
+		return nil
+	}
+
+	sample := &temperatureSample{Offset: offset, Values: make([]float64, len(s.Sensors))}
+	for i, sensor := range s.Sensors {
+		value, err := readMillidegrees(sensor.Input)
+		if err != nil {
+			return err
+		}
+		sample.Values[i] = value
+	}
+	s.Samples = append(s.Samples, sample)
+	if len(s.Samples) >= MaxStatsSamples {
+		s.Samples = decimate(s.Samples)
+		s.Interval *= 2
+	}
+	return nil
+}
+
+func (s *temperatureStats) Csv() string {
+	var output strings.Builder
+
+	output.WriteString("\"time ms\"")
+	for _, sensor := range s.Sensors {
+		fmt.Fprintf(&output, ",\"%s C\"", sensor.Name)
+	}
+	output.WriteString("\n")
+
+	for _, sample := range s.Samples {
+		fmt.Fprintf(&output, "%d", sample.Offset.Milliseconds())
+		for _, value := range sample.Values {
+			fmt.Fprintf(&output, ",%0.1f", value)
+		}
+		output.WriteString("\n")
+	}
+	return output.String()
+}
+
+// nearest returns the sample taken closest to offset, or nil without samples.
+func (s *temperatureStats) nearest(offset time.Duration) *temperatureSample {
+	i := sort.Search(len(s.Samples), func(i int) bool { return s.Samples[i].Offset >= offset })
+	if i > 0 && (i == len(s.Samples) || offset-s.Samples[i-1].Offset < s.Samples[i].Offset-offset) {
+		return s.Samples[i-1]
+	}
+	if i == len(s.Samples) {
+		return nil
+	}
+	return s.Samples[i]
+}
+
+// Summarize returns the range of every sensor, and the intervals of metrics in which the read and write
+// throughput fell by more than ThrottleDrop from the average of the preceding ThrottleWindow intervals
+// while a sensor was at or above its threshold. Only the first interval of consecutive drops is reported.
+func (s *temperatureStats) Summarize(metrics []*DiskMetrics) *TemperatureSummary {
+	summary := &TemperatureSummary{Events: []*ThrottleEvent{}, Sensors: []*TemperatureSensorSummary{}}
+	for i, sensor := range s.Sensors {
+		sensorSummary := &TemperatureSensorSummary{Name: sensor.Name, Threshold: sensor.Threshold}
+		for j, sample := range s.Samples {
+			if j == 0 || sample.Values[i] < sensorSummary.Min {
+				sensorSummary.Min = sample.Values[i]
+			}
+			if j == 0 || sample.Values[i] > sensorSummary.Max {
+				sensorSummary.Max = sample.Values[i]
+			}
+		}
+		summary.Sensors = append(summary.Sensors, sensorSummary)
+	}
+	if len(s.Samples) == 0 {
+		return summary
+	}
+
+	dropping := false
+	for k, m := range metrics {
+		if k == 0 {
+			continue
+		}
+		var baseline float64
+		first := k - ThrottleWindow
+		if first < 0 {
+			first = 0
+		}
+		window := metrics[first:k]
+		for _, previous := range window {
+			baseline += previous.ReadMBps + previous.WriteMBps
+		}
+		baseline /= float64(len(window))
+
+		rate := m.ReadMBps + m.WriteMBps
+		if baseline <= 0 || rate >= (1-ThrottleDrop)*baseline {
+			dropping = false
+			continue
+		}
+
+		sample := s.nearest(m.Offset)
+		var event *ThrottleEvent
+		for i, sensor := range s.Sensors {
+			if sensor.Threshold > 0 && sample.Values[i] >= sensor.Threshold {
+				event = &ThrottleEvent{
+					Baseline:    baseline,
+					Rate:        rate,
+					Sensor:      sensor.Name,
+					Temperature: sample.Values[i],
+					Threshold:   sensor.Threshold,
+					Time:        m.Offset.Milliseconds(),
+				}
+				break
+			}
+		}
+		if event != nil && !dropping {
+			summary.Events = append(summary.Events, event)
+		}
+		dropping = event != nil
+	}
+	return summary
+}
+
+// TemperatureSummary returns the device's temperature summary, or nil when it has no temperature sensors.
+func (s *diskStats) TemperatureSummary() *TemperatureSummary {
+	if s.Temperature == nil {
+		return nil
+	}
+	return s.Temperature.Summarize(s.Metrics())
+}
+
+func (s *TemperatureSummary) String() string {
+	var output string
+	for _, sensor := range s.Sensors {
+		output += fmt.Sprintf("  Temperature %s: %0.1f to %0.1f C", sensor.Name, sensor.Min, sensor.Max)
+		if sensor.Threshold > 0 {
+			output += fmt.Sprintf(", threshold %0.1f C", sensor.Threshold)
+		}
+		output += "\n"
+	}
+	for _, e := range s.Events {
+		output += fmt.Sprintf(
+			"  WARNING: Throughput fell to %0.2f MiB/sec from %0.2f MiB/sec at %d ms while %s was %0.1f C, at or above its %0.1f C threshold. The device may be thermally throttling.\n",
+			e.Rate, e.Baseline, e.Time, e.Sensor, e.Temperature, e.Threshold,
+		)
+	}
+	return output
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTemperatureStats(t *testing.T) {
+	root := fakeRoot(t, map[string]string{
+		"devices/nvme0/hwmon2/name":        "nvme\n",
+		"devices/nvme0/hwmon2/temp1_input": "45850\n",
+		"devices/nvme0/hwmon2/temp1_label": "Composite\n",
+		"devices/nvme0/hwmon2/temp1_max":   "75850\n",
+		"devices/nvme0/hwmon2/temp1_crit":  "85850\n",
+		"devices/nvme0/hwmon2/temp2_input": "51000\n",
+		"devices/nvme0/hwmon2/temp2_crit":  "90000\n",
+		"devices/other/hwmon7/temp1_input": "30000\n",
+	})
+	for link, target := range map[string]string{
+		"sys/block/nvme0n1/device":    "devices/nvme0",
+		"sys/class/hwmon/hwmon2":      "devices/nvme0/hwmon2",
+		"sys/class/hwmon/hwmon7":      "devices/other/hwmon7",
+		"devices/nvme0/hwmon2/device": "devices/nvme0",
+		"devices/other/hwmon7/device": "devices/other",
+	} {
+		if err := os.MkdirAll(path.Dir(path.Join(root, link)), 0755); err != nil {
+			t.Fatalf("Unable to create fake tree. %s\n", err)
+		}
+		if err := os.Symlink(path.Join(root, target), path.Join(root, link)); err != nil {
+			t.Fatalf("Unable to create fake tree. %s\n", err)
+		}
+	}
+
+	// The NVMe controller's hwmon directory is found through the device and through /sys/class/hwmon, but used once.
+	s := newTemperatureStats("nvme0n1")
+	if s == nil || len(s.Sensors) != 2 {
+		t.Fatalf("Expected 2 sensors, found %+v\n", s)
+	}
+	if s.Sensors[0].Name != "Composite" || s.Sensors[0].Threshold != 75.85 || s.Sensors[1].Name != "nvme temp2" || s.Sensors[1].Threshold != 90 {
+		t.Errorf("Unexpected sensors %+v %+v\n", s.Sensors[0], s.Sensors[1])
+	}
+	if newTemperatureStats("sda") != nil {
+		t.Errorf("Expected no sensors for a device without hwmon\n")
+	}
+
+	start := time.Now()
+	if err := s.UpdateStats(start); err != nil {
+		t.Fatalf("Unable to read temperatures. %s\n", err)
+	}
+	if csv := s.Csv(); !strings.HasPrefix(csv, "\"time ms\",\"Composite C\",\"nvme temp2 C\"\n") || !strings.HasSuffix(csv, ",45.9,51.0\n") {
+		t.Errorf("Unexpected temperature CSV %q\n", csv)
+	}
+
+	// Sensors are read at most once per interval, however often the device is sampled.
+	if err := s.UpdateStats(start); err != nil || len(s.Samples) != 1 {
+		t.Errorf("Expected one temperature sample within the interval, found %d. %v\n", len(s.Samples), err)
+	}
+	s.Samples[0].Offset -= TemperatureInterval
+	if err := s.UpdateStats(start); err != nil || len(s.Samples) != 2 {
+		t.Errorf("Expected a second temperature sample after the interval, found %d. %v\n", len(s.Samples), err)
+	}
+}
+
+func TestThrottleEvents(t *testing.T) {
+	s := &temperatureStats{Sensors: []*temperatureSensor{{Name: "Composite", Threshold: 75}, {Name: "Sensor 2"}}}
+	var metrics []*DiskMetrics
+	rates := []float64{100, 100, 100, 100, 40, 40, 100, 50}
+	temps := []float64{60, 65, 70, 74, 76, 78, 72, 70}
+	for i, rate := range rates {
+		offset := time.Duration(i+1) * time.Second
+		metrics = append(metrics, &DiskMetrics{Offset: offset, WriteMBps: rate})
+		// Sensors are read just after the device's counters.
+		s.Samples = append(s.Samples, &temperatureSample{Offset: offset + time.Millisecond, Values: []float64{temps[i], 99}})
+	}
+
+	if s.nearest(0) != s.Samples[0] || s.nearest(3*time.Second+600*time.Millisecond) != s.Samples[3] || s.nearest(time.Minute) != s.Samples[7] {
+		t.Errorf("Unexpected nearest temperature samples\n")
+	}
+
+	summary := s.Summarize(metrics)
+	if len(summary.Sensors) != 2 || summary.Sensors[0].Min != 60 || summary.Sensors[0].Max != 78 {
+		t.Errorf("Unexpected sensor summaries %+v\n", summary.Sensors[0])
+	}
+	// The drop at 8 seconds happens below the threshold, and Sensor 2 has no threshold.
+	if len(summary.Events) != 1 {
+		t.Fatalf("Expected one throttle event, found %+v\n", summary.Events)
+	}
+	if e := summary.Events[0]; e.Time != 5000 || e.Rate != 40 || e.Baseline != 100 || e.Temperature != 76 || e.Sensor != "Composite" {
+		t.Errorf("Unexpected throttle event %+v\n", e)
+	}
+	if output := summary.String(); !strings.Contains(output, "WARNING: Throughput fell to 40.00 MiB/sec from 100.00 MiB/sec at 5000 ms while Composite was 76.0 C") {
+		t.Errorf("Unexpected temperature summary %q\n", output)
+	}
+}
@@ -3,8 +3,10 @@ package main
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"path/filepath"
 	"strings"
 )
 
@@ -52,6 +54,14 @@ func DevFromPath(path string) string {
 	if Debug {
 		log.Printf("Discovering device for path %s\n", path)
 	}
+	if isBlockDevice(path) {
+		return baseDevice(filepath.Base(path))
+	}
+	if absPath, err := filepath.Abs(path); err == nil {
+		// Mount points are absolute, so relative paths would never match one.
+		path = absPath
+	}
+
 	mountsFile, err := os.Open("/proc/self/mounts")
 	if err != nil {
 		if Debug {
@@ -84,7 +94,11 @@ func DevFromPath(path string) string {
 		log.Printf("WARNING: Unable to close /proc/self/mounts. %s\n", closeErr)
 	}
 
-	device = strings.TrimPrefix(device, "/dev/")
+	return baseDevice(strings.TrimPrefix(device, "/dev/"))
+}
+
+// baseDevice - Strip any partition number from a block device name
+func baseDevice(device string) string {
 	if strings.HasPrefix(device, "sd") {
 		device = strings.TrimRight(device, "123456789")
 	}
@@ -100,6 +114,45 @@ func DevFromPath(path string) string {
 	return device
 }
 
+// alignDown - Round offset down to a multiple of size, so block stamps and direct IO stay aligned
+func alignDown(offset int64, size int64) int64 {
+	if size < 1 {
+		return offset
+	}
+	return offset - offset%size
+}
+
+// isBlockDevice - Report whether path is a block device node rather than a directory or regular file
+func isBlockDevice(path string) bool {
+	fInfo, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+
+	return fInfo.Mode()&os.ModeDevice != 0 && fInfo.Mode()&os.ModeCharDevice == 0
+}
+
+// targetSize - Return the usable size of a block device, or fallback for any other path
+func targetSize(path string, fallback int64) int64 {
+	if !isBlockDevice(path) {
+		return fallback
+	}
+
+	dev, err := os.Open(path)
+	if err != nil {
+		log.Printf("WARNING: Unable to open %s to determine its size. %s\n", path, err)
+		return fallback
+	}
+	defer dev.Close()
+
+	size, err := dev.Seek(0, io.SeekEnd)
+	if err != nil || size < 1 {
+		log.Printf("WARNING: Unable to determine the size of %s. %s\n", path, err)
+		return fallback
+	}
+	return size
+}
+
 func humanizeSize(f float64, base2 bool) string {
 
 	if base2 {